@@ -0,0 +1,510 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/adriangalilea/xdg-dirs/internal/audit"
+	"github.com/adriangalilea/xdg-dirs/internal/doctor"
+	"github.com/adriangalilea/xdg-dirs/internal/setup"
+	"github.com/adriangalilea/xdg-dirs/internal/updater"
+	"github.com/adriangalilea/xdg-dirs/internal/xdgdirs"
+)
+
+// runGet prints the resolved value of one variable, like xdg-user-dir. It only
+// reads: generated.dirs is not written and nothing is logged unless it fails.
+func runGet(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: xdg-dirs get <NAME>")
+		return 2
+	}
+
+	userDirs, ok := readUserDirs()
+	if !ok {
+		return 1
+	}
+
+	key, ok := xdgdirs.ResolveName(args[0], userDirs)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: unknown directory %q\n", args[0])
+		return 1
+	}
+	fmt.Println(userDirs[key])
+	return 0
+}
+
+// runSet defines one directory in user.dirs, like xdg-user-dirs-update --set.
+func runSet(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: xdg-dirs set <NAME> <PATH>")
+		return 2
+	}
+	key, ok := settableKey(args[0])
+	if !ok {
+		return 1
+	}
+	if err := xdgdirs.NewXDGDirs(log).SetUserDir(key, args[1]); err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runUnset removes one directory from user.dirs so its default applies again.
+func runUnset(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: xdg-dirs unset <NAME>")
+		return 2
+	}
+	key, ok := editableKey(args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: unknown directory %q\n", args[0])
+		return 1
+	}
+	removed, err := xdgdirs.NewXDGDirs(log).UnsetUserDir(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return 1
+	}
+	if !removed {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %s is not set in user.dirs\n", key)
+		return 1
+	}
+	return 0
+}
+
+// settableKey resolves name for set to a standard variable or a registered
+// extra. Any other name would be flagged as unknown on every run, so it has
+// to be registered in extras.dirs first; the error says where.
+func settableKey(name string) (string, bool) {
+	u, ok := readOnlyUpdater()
+	if !ok {
+		return "", false
+	}
+	x := u.XDGDirs()
+	extras, _, err := x.Registry()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return "", false
+	}
+	keys := make(map[string]string)
+	for _, key := range xdgdirs.KnownKeys() {
+		keys[key] = ""
+	}
+	for _, extra := range extras {
+		keys[extra.Key] = ""
+	}
+	if key, ok := xdgdirs.ResolveName(name, keys); ok {
+		return key, true
+	}
+
+	full := strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(full, "XDG_") {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: unknown directory %q\n", name)
+		return "", false
+	}
+	path, err := x.ExtrasPath()
+	if err != nil {
+		path = "extras.dirs"
+	}
+	fmt.Fprintf(os.Stderr, "xdg-dirs: %s is not a registered directory; register it in %s first\n", full, path)
+	return "", false
+}
+
+// editableKey resolves name for unset. Besides the names get accepts, any
+// full XDG_* name is allowed, so a line is found even if it did not resolve.
+func editableKey(name string) (string, bool) {
+	userDirs, ok := readUserDirs()
+	if !ok {
+		return "", false
+	}
+	if key, ok := xdgdirs.ResolveName(name, userDirs); ok {
+		return key, true
+	}
+	name = strings.ToUpper(strings.TrimSpace(name))
+	return name, strings.HasPrefix(name, "XDG_")
+}
+
+// runList prints the merged directories without writing anything.
+func runList(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: xdg-dirs list")
+		return 2
+	}
+	userDirs, ok := readUserDirs()
+	if !ok {
+		return 1
+	}
+	keys := make([]string, 0, len(userDirs))
+	for key := range userDirs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%s=%s\n", key, userDirs[key])
+	}
+	return 0
+}
+
+// runExplain prints every variable with the layer its value came from and,
+// for user.dirs, the line and the text before expansion. NAME arguments limit
+// the output to those variables.
+func runExplain(args []string) int {
+	u, ok := readOnlyUpdater()
+	if !ok {
+		return 1
+	}
+	origins, err := u.Explain()
+	if err != nil {
+		showParseError(err)
+		log.Error("Failed to get user directories: %v", err)
+		return 1
+	}
+
+	if len(args) > 0 {
+		byKey := make(map[string]xdgdirs.Origin, len(origins))
+		values := make(map[string]string, len(origins))
+		for _, origin := range origins {
+			byKey[origin.Key] = origin
+			values[origin.Key] = origin.Value
+		}
+		origins = origins[:0]
+		for _, name := range args {
+			key, ok := xdgdirs.ResolveName(name, values)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "xdg-dirs: unknown directory %q\n", name)
+				return 1
+			}
+			origins = append(origins, byKey[key])
+		}
+	}
+
+	if jsonOutput {
+		out, err := json.MarshalIndent(origins, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(out))
+		return 0
+	}
+	for i, origin := range origins {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s=%s\n", origin.Key, origin.Value)
+		switch origin.Source {
+		case xdgdirs.SourceUser:
+			fmt.Printf("  source: user.dirs (%s:%d)\n", origin.File, origin.Line)
+			fmt.Printf("  raw:    %s\n", origin.Raw)
+		case xdgdirs.SourceEnv:
+			fmt.Println("  source: env (inherited from the environment)")
+		default:
+			fmt.Println("  source: default (built in)")
+		}
+		for i, ref := range origin.Refs {
+			label := "  reads: "
+			if i > 0 {
+				label = "         "
+			}
+			fmt.Printf("%s $%s=%s (%s)\n", label, ref.Name, ref.Value, ref.Source)
+		}
+		for _, skipped := range origin.Skipped {
+			if skipped.Source == xdgdirs.SourceUser {
+				fmt.Printf("  empty:  user.dirs (%s:%d) = %q, so the next source applies\n", skipped.File, skipped.Line, skipped.Raw)
+			} else {
+				fmt.Printf("  empty:  %s, so the next source applies\n", skipped.Source)
+			}
+		}
+	}
+	return 0
+}
+
+// Exit statuses of check. 2 is taken by usage errors.
+const (
+	checkWarnings = 1
+	checkErrors   = 3
+	checkFailed   = 4 // the check itself could not run
+)
+
+// runCheck reports problems with the resolved directories and the files
+// behind them. It exits 0 when there are none, 1 for warnings only, 3 if
+// there is at least one error and 4 if the check could not be done.
+func runCheck(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: xdg-dirs check [--json]")
+		return 2
+	}
+	u, ok := readOnlyUpdater()
+	if !ok {
+		return checkFailed
+	}
+	findings, err := u.Check(strict)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return checkFailed
+	}
+	errors, warnings := doctor.Count(findings)
+
+	if jsonOutput {
+		if findings == nil {
+			findings = []doctor.Finding{}
+		}
+		out, err := json.MarshalIndent(struct {
+			Findings []doctor.Finding `json:"findings"`
+			Errors   int              `json:"errors"`
+			Warnings int              `json:"warnings"`
+		}{findings, errors, warnings}, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+			return checkFailed
+		}
+		fmt.Println(string(out))
+	} else {
+		for _, f := range findings {
+			fmt.Println(f)
+		}
+		if len(findings) == 0 {
+			fmt.Println("No problems found.")
+		} else {
+			fmt.Printf("%d error(s), %d warning(s)\n", errors, warnings)
+		}
+	}
+
+	switch {
+	case errors > 0:
+		return checkErrors
+	case warnings > 0:
+		return checkWarnings
+	}
+	return 0
+}
+
+// runImport copies the non-default directories of the legacy user-dirs.dirs
+// into user.dirs, or only shows them with --dry-run.
+func runImport(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: xdg-dirs import [--dry-run]")
+		return 2
+	}
+	u, ok := readOnlyUpdater()
+	if !ok {
+		return 1
+	}
+	x := u.XDGDirs()
+	plan, err := x.PlanImport()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return 1
+	}
+	if plan.Source == "" {
+		fmt.Println("No user-dirs.dirs to import.")
+		return 0
+	}
+	path, err := x.UserDirsPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("From %s:\n", plan.Source)
+	for _, entry := range plan.Entries {
+		def := entry.Default
+		if def == "" {
+			def = "none"
+		}
+		fmt.Printf("  + %s=%s (default: %s)\n", entry.Key, entry.Value, def)
+	}
+	for _, entry := range plan.Kept {
+		fmt.Printf("  = %s=%s (already set in user.dirs, kept)\n", entry.Key, entry.Value)
+	}
+	switch {
+	case len(plan.Entries) == 0:
+		fmt.Println("Nothing to import: every directory is the default or already in user.dirs.")
+	case dryRun:
+		fmt.Printf("Would add %d line(s) to %s.\n", len(plan.Entries), path)
+		return 0
+	}
+	if err := x.ApplyImport(plan); err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return 1
+	}
+	if len(plan.Entries) > 0 {
+		fmt.Printf("Added %d line(s) to %s.\n", len(plan.Entries), path)
+	}
+	return 0
+}
+
+// runBackups lists the backups, oldest first.
+func runBackups(args []string) int {
+	if len(args) > 1 || (len(args) == 1 && args[0] != "list") {
+		fmt.Fprintln(os.Stderr, "usage: xdg-dirs backups [list]")
+		return 2
+	}
+	u, ok := readOnlyUpdater()
+	if !ok {
+		return 1
+	}
+	backups, err := u.XDGDirs().Backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return 1
+	}
+	if len(backups) == 0 {
+		fmt.Println("No backups.")
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSAVED\tFILE")
+	for _, b := range backups {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", b.ID, b.Time.Local().Format("2006-01-02 15:04:05"), b.Kind)
+	}
+	w.Flush()
+	return 0
+}
+
+// runRestore puts one backup back in place.
+func runRestore(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: xdg-dirs restore <ID>")
+		return 2
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id < 1 {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: invalid backup ID %q (see xdg-dirs backups)\n", args[0])
+		return 2
+	}
+	u, ok := readOnlyUpdater()
+	if !ok {
+		return 1
+	}
+	x := u.XDGDirs()
+	restored, err := x.Restore(id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Restored backup %d to %s.\n", id, restored.Target)
+	if restored.Kind == xdgdirs.BackupGenerated {
+		fmt.Println("This is only a snapshot: the next export writes generated.dirs again from user.dirs.")
+		return 0
+	}
+	if len(restored.Set) > 0 {
+		path, err := x.UserDirsPath()
+		if err != nil {
+			path = "user.dirs"
+		}
+		fmt.Printf("Set in %s, so that it lasts:\n", path)
+		for _, entry := range restored.Set {
+			fmt.Printf("  %s=%s\n", entry.Key, entry.Value)
+		}
+	}
+	return 0
+}
+
+// Exit statuses of audit. 2 is taken by usage errors.
+const (
+	auditFindings = 1
+	auditFailed   = 3 // the audit itself could not run
+)
+
+// runAudit lists the files in $HOME the apps database knows, with the fix for
+// each. It exits 1 when there is anything to relocate and 3 if the audit
+// could not be done.
+func runAudit(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: xdg-dirs audit [--json] [--depth N]")
+		return 2
+	}
+	depth := audit.DefaultDepth
+	if auditDepth != "" {
+		n, err := strconv.Atoi(auditDepth)
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "xdg-dirs: invalid --depth %q\n", auditDepth)
+			return 2
+		}
+		depth = n
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return auditFailed
+	}
+	u, ok := readOnlyUpdater()
+	if !ok {
+		return auditFailed
+	}
+	findings, err := u.Audit(home, depth)
+	if err != nil {
+		showParseError(err)
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return auditFailed
+	}
+
+	if jsonOutput {
+		if findings == nil {
+			findings = []audit.Finding{}
+		}
+		out, err := json.MarshalIndent(struct {
+			Home     string          `json:"home"`
+			Findings []audit.Finding `json:"findings"`
+		}{home, findings}, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+			return auditFailed
+		}
+		fmt.Println(string(out))
+	} else {
+		for _, f := range findings {
+			fmt.Println(f.String(home))
+		}
+		if len(findings) == 0 {
+			fmt.Println("Nothing to relocate in $HOME.")
+		} else {
+			fmt.Printf("%d file(s) to relocate\n", len(findings))
+		}
+	}
+	if len(findings) > 0 {
+		return auditFindings
+	}
+	return 0
+}
+
+// readOnlyUpdater prepares the same merge as export, for the commands that
+// only read, and for those that manage xdg-dirs' own files, which live where
+// the merge puts XDG_CONFIG_HOME and XDG_STATE_HOME. Problems in user.dirs
+// go to stderr: reading must not write the log.
+func readOnlyUpdater() (*updater.Updater, bool) {
+	inherited, err := setup.ResetEnv(log)
+	if err != nil {
+		log.Error("Failed to reset environment: %v", err)
+		return nil, false
+	}
+	u, err := newUpdater(inherited)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+		return nil, false
+	}
+	u.XDGDirs().SetDiagnostics(os.Stderr)
+	return u, true
+}
+
+// readUserDirs runs the same merge as export, for the read-only commands.
+func readUserDirs() (map[string]string, bool) {
+	u, ok := readOnlyUpdater()
+	if !ok {
+		return nil, false
+	}
+	userDirs, err := u.GetUserDirs()
+	if err != nil {
+		showParseError(err)
+		log.Error("Failed to get user directories: %v", err)
+		return nil, false
+	}
+	return userDirs, true
+}
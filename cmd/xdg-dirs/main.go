@@ -1,68 +1,384 @@
 package main
 
 import (
-	"flag"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/adriangalilea/xdg-dirs/internal/apps"
+	"github.com/adriangalilea/xdg-dirs/internal/cli"
 	"github.com/adriangalilea/xdg-dirs/internal/conf"
 	"github.com/adriangalilea/xdg-dirs/internal/logger"
 	"github.com/adriangalilea/xdg-dirs/internal/setup"
+	"github.com/adriangalilea/xdg-dirs/internal/shell"
 	"github.com/adriangalilea/xdg-dirs/internal/updater"
+	"github.com/adriangalilea/xdg-dirs/internal/xdgdirs"
 )
 
 var log *logger.Logger
 
+// Options shared by every command.
+var (
+	debug       bool
+	debugFD     string
+	logFilePath string
+	logFormat   string
+	logMaxSize  string
+	logBackups  string
+	logMaxAge   string
+	logCompress bool
+	precedence  string
+	strict      bool
+)
+
+// Options of the export command.
+var (
+	dryRun          bool
+	createDirs      bool
+	shellName       string
+	runtimeFallback bool
+	noCache         bool
+)
+
+// Options of the commands that report instead of exporting.
+var (
+	jsonOutput bool
+	auditDepth string
+)
+
+func newApp() *cli.App {
+	debug, debugFD, logFilePath, logFormat = false, "", conf.DefaultLogFilePath, logger.FormatText
+	logMaxSize, logBackups, logMaxAge, logCompress = "", "", "", false
+	precedence, strict = "", false
+	dryRun, createDirs, shellName, runtimeFallback, noCache = false, false, shell.Auto, false, false
+	jsonOutput, auditDepth = false, ""
+
+	global := &cli.FlagSet{}
+	global.Bool(&debug, "d", "debug", "Enable debug output")
+	global.String(&debugFD, "", "debug-fd", "FD", "Send debug output to file descriptor FD instead of stderr")
+	global.String(&logFilePath, "l", "log-file", "PATH", "Specify the log file path")
+	global.String(&logFormat, "", "log-format", "FORMAT", "Log format: text or json")
+	global.String(&logMaxSize, "", "log-max-size", "SIZE", "Rotate the log at this size (default 10M)")
+	global.String(&logBackups, "", "log-max-backups", "N", "Rotated logs to keep, 0 for all (default 5)")
+	global.String(&logMaxAge, "", "log-max-age", "AGE", "Delete rotated logs older than AGE, e.g. 30d, 0 to keep (default 30d)")
+	global.Bool(&logCompress, "", "log-compress", "Gzip rotated logs")
+	global.String(&precedence, "p", "precedence", "ORDER", "Source order for every variable, e.g. env,user,default (default user,default)")
+	global.Bool(&strict, "", "strict", "Treat any problem found in user.dirs as fatal")
+
+	exportFlags := &cli.FlagSet{}
+	exportFlags.Bool(&dryRun, "n", "dry-run", "Simulate changes without applying them")
+	exportFlags.Bool(&createDirs, "c", "create-dirs", "Create directories if they don't exist")
+	exportFlags.Bool(&runtimeFallback, "", "runtime-fallback", "Create $TMPDIR/xdg-runtime-$UID if XDG_RUNTIME_DIR is missing or invalid")
+	exportFlags.Bool(&noCache, "", "no-cache", "Regenerate even if nothing changed since the last run")
+	exportFlags.String(&shellName, "s", "shell", "NAME", "Shell syntax for the exports ("+strings.Join(shell.Supported(), ", ")+" or auto)")
+
+	explainFlags := &cli.FlagSet{}
+	explainFlags.Bool(&jsonOutput, "", "json", "Print a JSON array instead of text")
+
+	checkFlags := &cli.FlagSet{}
+	checkFlags.Bool(&jsonOutput, "", "json", "Print a JSON object instead of text")
+
+	importFlags := &cli.FlagSet{}
+	importFlags.Bool(&dryRun, "n", "dry-run", "Show what would be imported without changing user.dirs")
+
+	auditFlags := &cli.FlagSet{}
+	auditFlags.Bool(&jsonOutput, "", "json", "Print a JSON object instead of text")
+	auditFlags.String(&auditDepth, "", "depth", "N", "Levels below $HOME to look at (default 2)")
+
+	return &cli.App{
+		Name:    "xdg-dirs",
+		Help:    conf.HelpMessage,
+		Global:  global,
+		Default: "export",
+		Before:  startLogger,
+		Commands: []*cli.Command{
+			{
+				Name:        "export",
+				Summary:     "Write generated.dirs and print the export lines for your shell to eval.",
+				Description: "This is what runs when no command is given, so eval \"$(xdg-dirs)\" keeps working.",
+				Flags:       exportFlags,
+				Run:         runExport,
+			},
+			{
+				Name:        "get",
+				Args:        "<NAME>",
+				Summary:     "Print one resolved directory, like xdg-user-dir.",
+				Description: "NAME is a short name (DOWNLOAD, CACHE) or a full one (XDG_DOWNLOAD_DIR).\nNothing is written; unknown names exit with status 1.",
+				Run:         runGet,
+			},
+			{
+				Name:        "set",
+				Args:        "<NAME> <PATH>",
+				Summary:     "Set a directory in user.dirs, like xdg-user-dirs-update --set.",
+				Description: "Only the lines defining NAME change. NAME is a standard directory or a registered extra;\nregister a new custom directory in extras.dirs first.",
+				Run:         runSet,
+			},
+			{
+				Name:    "unset",
+				Args:    "<NAME>",
+				Summary: "Remove a directory from user.dirs so its default applies again.",
+				Run:     runUnset,
+			},
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Summary: "Print every resolved directory as NAME=value, sorted by name.",
+				Run:     runList,
+			},
+			{
+				Name:        "explain",
+				Args:        "[NAME...]",
+				Summary:     "Show where every resolved directory comes from.",
+				Description: "For each variable: the final value, the source that won (env, user or default)\nand, for user.dirs, the file, line and the text before expansion.",
+				Flags:       explainFlags,
+				Run:         runExplain,
+			},
+			{
+				Name:        "check",
+				Aliases:     []string{"doctor"},
+				Summary:     "Report problems with the resolved directories, user.dirs and generated.dirs.",
+				Description: "Looks for missing, non-directory and unwritable directories, relative or\nunexpanded values, variables sharing a target, unknown variables in user.dirs\nand a stale generated.dirs. Nothing is changed.\n\nExit status: 0 no problems, 1 warnings only, 3 at least one error, 4 the\ncheck could not be done (for example user.dirs is unreadable).",
+				Flags:       checkFlags,
+				Run:         runCheck,
+			},
+			{
+				Name:        "import",
+				Summary:     "Copy the directories of an existing user-dirs.dirs into user.dirs.",
+				Description: "Reads ~/.config/user-dirs.dirs, or the backup of it when xdg-dirs already\nreplaced it, and adds every directory that differs from the default to\nuser.dirs. Lines user.dirs already has are kept. The first export does this\nonce by itself; --dry-run shows the changes without making them.",
+				Flags:       importFlags,
+				Run:         runImport,
+			},
+			{
+				Name:        "backups",
+				Args:        "[list]",
+				Summary:     "List the saved versions of user-dirs.dirs and generated.dirs.",
+				Description: "A version is saved whenever xdg-dirs replaces one of these files with\ndifferent content. The " + strconv.Itoa(xdgdirs.BackupRetention) + " most recent of each are kept.",
+				Run:         runBackups,
+			},
+			{
+				Name:        "restore",
+				Args:        "<ID>",
+				Summary:     "Put a backup listed by `backups` back in place.",
+				Description: "The file it replaces is backed up first. The next export writes both files\nagain from user.dirs, so restoring user-dirs.dirs also sets its directories\nin user.dirs; a restored generated.dirs is only a snapshot until then.",
+				Run:         runRestore,
+			},
+			{
+				Name:        "audit",
+				Summary:     "List the files in $HOME that belong in the XDG directories.",
+				Description: "Matches dotfiles and dot-directories against the apps database and tells\nwhich program owns each one and the fix: a variable to enable with @apps,\na setting in the program's configuration, or none (unsupported). Only\ndot-directories are entered, up to --depth levels. Nothing is changed.\n\nExit status: 0 nothing found, 1 something to relocate, 3 the audit could not\nbe done (for example $HOME or user.dirs is unreadable).",
+				Flags:       auditFlags,
+				Run:         runAudit,
+			},
+		},
+	}
+}
+
+// startLogger creates the logger once the global options are parsed.
+func startLogger() error {
+	log = logger.NewLogger(debug, logFilePath)
+	if err := log.SetFormat(logFormat); err != nil {
+		return err
+	}
+	retention, err := parseRetention()
+	if err != nil {
+		return err
+	}
+	log.SetRetention(retention)
+	if debugFD == "" {
+		return nil
+	}
+	fd, err := strconv.Atoi(debugFD)
+	if err != nil || fd < 0 {
+		return fmt.Errorf("invalid --debug-fd %q", debugFD)
+	}
+	if fd == 1 {
+		return fmt.Errorf("--debug-fd 1 would mix debug output into the exports")
+	}
+	f := os.NewFile(uintptr(fd), "debug-fd")
+	if _, err := f.Stat(); err != nil {
+		return fmt.Errorf("--debug-fd %d is not open: %w", fd, err)
+	}
+	log.SetDiagnostics(f)
+	return nil
+}
+
+// parseRetention applies the --log-* options over logger.DefaultRetention.
+func parseRetention() (logger.Retention, error) {
+	r := logger.DefaultRetention
+	r.Compress = logCompress
+	if logMaxSize != "" {
+		size, err := logger.ParseSize(logMaxSize)
+		if err != nil {
+			return r, fmt.Errorf("--log-max-size: %w", err)
+		}
+		r.MaxSize = size
+	}
+	if logBackups != "" {
+		n, err := strconv.Atoi(logBackups)
+		if err != nil || n < 0 {
+			return r, fmt.Errorf("--log-max-backups: invalid count %q", logBackups)
+		}
+		r.MaxBackups = n
+	}
+	if logMaxAge != "" {
+		age, err := logger.ParseAge(logMaxAge)
+		if err != nil {
+			return r, fmt.Errorf("--log-max-age: %w", err)
+		}
+		r.MaxAge = age
+	}
+	return r, nil
+}
+
 func main() {
-	// Parse command-line flags
-	debug := flag.Bool("d", false, "Enable debug output")
-	dryRun := flag.Bool("n", false, "Simulate changes without applying them")
-	createDirs := flag.Bool("c", false, "Create directories if they don't exist")
-	logFilePath := flag.String("l", conf.DefaultLogFilePath, "Specify the log file path")
-	help := flag.Bool("help", false, "Show help message")
-	flag.BoolVar(help, "h", false, "Show help message")
-	flag.Parse()
+	os.Exit(newApp().Run(os.Args[1:]))
+}
 
-	// Display help message if requested
-	if *help {
-		fmt.Println(conf.HelpMessage)
-		os.Exit(0)
+// newUpdater creates an updater that merges with the inherited environment
+// under the --precedence order, if one was given, and --strict.
+func newUpdater(inherited map[string]string) (*updater.Updater, error) {
+	u := updater.NewUpdater(log)
+	u.SetInherited(inherited)
+	u.SetStrict(strict)
+	if precedence != "" {
+		order, err := xdgdirs.ParseOrder(precedence)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --precedence: %w", err)
+		}
+		u.SetPrecedence(order)
 	}
+	return u, nil
+}
 
-	log = logger.NewLogger(*debug, *logFilePath)
+// showParseError prints the diagnostics that made a --strict run fail on
+// stderr: they are for the user to fix, not only for the log file.
+func showParseError(err error) {
+	var parseErr *xdgdirs.ParseError
+	if errors.As(err, &parseErr) {
+		fmt.Fprintf(os.Stderr, "xdg-dirs: %v\n", err)
+	}
+}
+
+// requiredDirs lists the directories this run made sure exist, with
+// --create-dirs and --runtime-fallback: a cached result is only reused while
+// they still do.
+func requiredDirs(userDirs map[string]string, appVars []apps.Export) []string {
+	var dirs []string
+	if createDirs {
+		for key, dir := range userDirs {
+			if dir != "" && !xdgdirs.IsSearchPath(key) {
+				dirs = append(dirs, filepath.Clean(dir)) // as ensureDirectories created it
+			}
+		}
+		for _, e := range appVars {
+			dirs = append(dirs, e.Dir())
+		}
+	}
+	if runtimeFallback && userDirs["XDG_RUNTIME_DIR"] != "" {
+		dirs = append(dirs, userDirs["XDG_RUNTIME_DIR"])
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// runExport is the classic behaviour: merge, write generated.dirs and print
+// the exports, which are the only thing ever written to stdout.
+func runExport(args []string) int {
+	shellFamily, err := shell.Parse(shellName)
+	if err != nil {
+		log.Fatal("Invalid shell: %v", err)
+	}
 
 	// Perform initial setup
-	if err := setup.Prepare(log); err != nil {
+	inherited, err := setup.Prepare(log)
+	if err != nil {
 		log.Fatal("Failed to perform initial setup: %v", err)
 	}
 
 	// Create updater instance
-	updaterInstance := updater.NewUpdater(log)
+	updaterInstance, err := newUpdater(inherited)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	updaterInstance.SetShell(shellFamily)
+	log.Debug("Emitting exports for %s", shellFamily)
+
+	// Fast path: nothing changed since a run with the same options, so print
+	// what it printed and write nothing
+	options := []string{
+		"precedence=" + precedence, "strict=" + strconv.FormatBool(strict),
+		"create-dirs=" + strconv.FormatBool(createDirs), "runtime-fallback=" + strconv.FormatBool(runtimeFallback),
+	}
+	if !dryRun && !noCache {
+		if exports, ok := updaterInstance.CachedExports(options...); ok {
+			log.Export(exports)
+			return 0
+		}
+	}
+
+	// Carry the directories of an existing user-dirs.dirs over, once, before
+	// the merge reads user.dirs
+	plan, err := updaterInstance.ImportLegacy(dryRun)
+	if err != nil {
+		log.Warn("Failed to import user-dirs.dirs: %v", err)
+	} else if plan != nil && len(plan.Entries) > 0 && !dryRun {
+		keys := make([]string, len(plan.Entries))
+		for i, entry := range plan.Entries {
+			keys[i] = entry.Key
+		}
+		fmt.Fprintf(os.Stderr, "xdg-dirs: imported %s from %s into user.dirs\n", strings.Join(keys, ", "), plan.Source)
+	}
 
 	// Get user directories
 	userDirs, err := updaterInstance.GetUserDirs()
 	if err != nil {
+		showParseError(err)
 		log.Fatal("Failed to get user directories: %v", err)
 	}
 
+	if err := updaterInstance.CheckRuntimeDir(userDirs, inherited["XDG_RUNTIME_DIR"], runtimeFallback, dryRun); err != nil {
+		log.Fatal("Failed to check XDG_RUNTIME_DIR: %v", err)
+	}
+
 	// Update user directories
-	if err := updaterInstance.Update(userDirs, *createDirs, *dryRun); err != nil {
+	if err := updaterInstance.Update(userDirs, createDirs, dryRun); err != nil {
 		log.Fatal("Failed to update user directories: %v", err)
 	}
 
+	// Derive the variables of the apps opted into with @apps
+	appVars, err := updaterInstance.AppEnv(userDirs)
+	if err != nil {
+		log.Fatal("Failed to resolve app variables: %v", err)
+	}
+	if createDirs && !dryRun {
+		if err := updaterInstance.EnsureAppDirs(appVars); err != nil {
+			log.Fatal("Failed to create app directories: %v", err)
+		}
+	}
+
 	// Get the EXPORT env variables
-	exports := updaterInstance.ExportEnv(userDirs)
+	exports := updaterInstance.ExportEnv(userDirs, appVars...)
 
-	log.Debug("XDG environment variables to be exported:\n%s", exports)
+	log.Debug("Environment variables to be exported:\n%s", exports)
 
 	// Print the export commands for shell integration
 	log.Export(exports)
 
+	if !dryRun {
+		if err := updaterInstance.StoreExports(exports, requiredDirs(userDirs, appVars), options...); err != nil {
+			log.Debug("%v", err)
+		}
+	}
+
 	log.Debug("Current environment variables:")
 	for _, env := range os.Environ() {
 		if strings.HasPrefix(env, "XDG_") {
 			log.Debug(env)
 		}
 	}
+	return 0
 }
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCaptured runs the CLI with args and returns what it wrote to stdout and
+// stderr.
+func runCaptured(t *testing.T, args ...string) (stdout, stderr string, status int) {
+	t.Helper()
+	outFile, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFile, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	realStdout, realStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = outFile, errFile
+	status = newApp().Run(args)
+	os.Stdout, os.Stderr = realStdout, realStderr
+
+	out, _ := os.ReadFile(outFile.Name())
+	errOut, _ := os.ReadFile(errFile.Name())
+	return string(out), string(errOut), status
+}
+
+// With -d, stdout must still be nothing but shell: eval "$(xdg-dirs -d)"
+// executes every byte of it.
+func TestDebugOutputStaysOffStdout(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	os.MkdirAll(filepath.Join(home, ".config", "xdg"), 0755)
+	os.WriteFile(filepath.Join(home, ".config", "xdg", "user.dirs"),
+		[]byte(`XDG_MUSIC_DIR="$HOME/my music"`+"\n"), 0644)
+
+	stdout, stderr, status := runCaptured(t, "-d", "--shell", "posix", "-l", filepath.Join(home, "test.log"))
+	if status != 0 {
+		t.Fatalf("exit status %d, stderr:\n%s", status, stderr)
+	}
+	if !strings.Contains(stderr, "DEBUG") {
+		t.Errorf("debug output missing from stderr:\n%s", stderr)
+	}
+
+	if out, err := exec.Command("sh", "-n", "-c", stdout).CombinedOutput(); err != nil {
+		t.Fatalf("stdout is not valid shell: %v\n%s\nstdout:\n%s", err, out, stdout)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		if !strings.HasPrefix(line, "export XDG_") {
+			t.Errorf("non-export line on stdout: %q", line)
+		}
+	}
+
+	got, err := exec.Command("sh", "-c", stdout+"\nprintf %s \"$XDG_MUSIC_DIR\"").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(home, "my music"); string(got) != want {
+		t.Errorf("eval gave XDG_MUSIC_DIR=%q, want %q", got, want)
+	}
+}
+
+// Backups live in the XDG_STATE_HOME export sets, and backups finds them
+// whether or not the calling shell exported it yet.
+func TestBackupsFollowMergedStateHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_STATE_HOME", "")
+	os.MkdirAll(filepath.Join(home, ".config", "xdg"), 0755)
+	os.WriteFile(filepath.Join(home, ".config", "xdg", "user.dirs"),
+		[]byte(`XDG_STATE_HOME="$HOME/state"`+"\n"), 0644)
+	os.WriteFile(filepath.Join(home, ".config", "user-dirs.dirs"),
+		[]byte(`XDG_MUSIC_DIR="$HOME/Music"`+"\n"), 0644)
+	logFile := filepath.Join(home, "test.log")
+
+	if _, stderr, status := runCaptured(t, "--shell", "posix", "-l", logFile); status != 0 {
+		t.Fatalf("export: exit status %d, stderr:\n%s", status, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(home, "state", "xdg-dirs", "backups")); err != nil {
+		t.Fatalf("no backups in the merged XDG_STATE_HOME: %v", err)
+	}
+	for _, exported := range []string{"", filepath.Join(home, "state")} {
+		t.Setenv("XDG_STATE_HOME", exported)
+		stdout, stderr, status := runCaptured(t, "-l", logFile, "backups")
+		if status != 0 || !strings.Contains(stdout, "user-dirs.dirs") {
+			t.Errorf("XDG_STATE_HOME=%q: backups = %q (status %d, stderr %q)", exported, stdout, status, stderr)
+		}
+	}
+}
+
+// import and the automatic import in export record the one-time import in the
+// same place, whatever XDG_STATE_HOME the calling shell has.
+func TestImportMarkerFollowsMergedStateHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_STATE_HOME", filepath.Join(home, "stale"))
+	os.MkdirAll(filepath.Join(home, ".config", "xdg"), 0755)
+	os.WriteFile(filepath.Join(home, ".config", "xdg", "user.dirs"),
+		[]byte(`XDG_STATE_HOME="$HOME/state"`+"\n"), 0644)
+	os.WriteFile(filepath.Join(home, ".config", "user-dirs.dirs"),
+		[]byte(`XDG_MUSIC_DIR="$HOME/tunes"`+"\n"), 0644)
+
+	if _, stderr, status := runCaptured(t, "-l", filepath.Join(home, "test.log"), "import"); status != 0 {
+		t.Fatalf("import: exit status %d, stderr:\n%s", status, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(home, "state", "xdg-dirs", "imported")); err != nil {
+		t.Errorf("marker not in the merged XDG_STATE_HOME: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "stale")); err == nil {
+		t.Error("import wrote under the inherited XDG_STATE_HOME")
+	}
+}
+
+// check tells a failure to run apart from the problems it found.
+func TestCheckFailureStatus(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	os.MkdirAll(filepath.Join(home, ".config", "xdg", "user.dirs"), 0755) // unreadable as a file
+
+	if _, stderr, status := runCaptured(t, "-l", filepath.Join(home, "test.log"), "check"); status != checkFailed {
+		t.Errorf("check exit status %d, want %d; stderr:\n%s", status, checkFailed, stderr)
+	}
+}
+
+// audit tells a failure to run apart from finding something to relocate.
+func TestAuditFailureStatus(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	os.MkdirAll(filepath.Join(home, ".config", "xdg", "user.dirs"), 0755) // unreadable as a file
+	os.Mkdir(filepath.Join(home, ".npm"), 0755)
+
+	if _, stderr, status := runCaptured(t, "-l", filepath.Join(home, "test.log"), "audit"); status != auditFailed {
+		t.Errorf("audit exit status %d, want %d; stderr:\n%s", status, auditFailed, stderr)
+	}
+	os.Remove(filepath.Join(home, ".config", "xdg", "user.dirs"))
+	if _, stderr, status := runCaptured(t, "-l", filepath.Join(home, "test.log"), "audit"); status != auditFindings {
+		t.Errorf("audit exit status %d, want %d; stderr:\n%s", status, auditFindings, stderr)
+	}
+}
+
+// get only reads: an unknown name fails without leaving generated.dirs, a
+// log or any state behind, even when user.dirs has something to report.
+func TestGetUnknownNameWritesNothing(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_STATE_HOME", "")
+	logFile := filepath.Join(home, "logs", "xdg-dirs.log")
+	os.MkdirAll(filepath.Join(home, ".config", "xdg"), 0755)
+	os.WriteFile(filepath.Join(home, ".config", "xdg", "user.dirs"),
+		[]byte(`XDG_CAHCE_HOME="$HOME/cache"`+"\n"), 0644)
+
+	stdout, stderr, status := runCaptured(t, "-l", logFile, "get", "NOPE")
+	if status == 0 || stdout != "" || !strings.Contains(stderr, "unknown directory") {
+		t.Errorf("get NOPE: status %d, stdout %q, stderr %q", status, stdout, stderr)
+	}
+	if !strings.Contains(stderr, "unknown variable XDG_CAHCE_HOME") {
+		t.Errorf("the user.dirs warning is not on stderr: %q", stderr)
+	}
+	for _, path := range []string{
+		filepath.Join(home, ".config", "xdg", "generated.dirs"),
+		filepath.Dir(logFile),
+		filepath.Join(home, ".local", "state"),
+	} {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("get created %s", path)
+		}
+	}
+}
+
+// A search path the environment already sets is the baseline user.dirs
+// extends, not a value that overrides it.
+func TestUserDirsExtendsInheritedSearchPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_DATA_DIRS", "/usr/share")
+	os.MkdirAll(filepath.Join(home, ".config", "xdg"), 0755)
+	os.WriteFile(filepath.Join(home, ".config", "xdg", "user.dirs"),
+		[]byte(`XDG_DATA_DIRS="/opt/share:$XDG_DATA_DIRS"`+"\n"), 0644)
+
+	stdout, stderr, status := runCaptured(t, "-l", filepath.Join(home, "test.log"), "get", "XDG_DATA_DIRS")
+	if status != 0 || stdout != "/opt/share:/usr/share\n" {
+		t.Errorf("get XDG_DATA_DIRS: status %d, stdout %q, stderr %q", status, stdout, stderr)
+	}
+}
+
+// Whatever set stores, get must read back unchanged: user.dirs gives \, $
+// and blanks a meaning inside double quotes.
+func TestSetGetRoundTrip(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("b", "/expanded")
+	logFile := filepath.Join(home, "test.log")
+
+	for _, path := range []string{
+		"/data/my music",
+		`/data/a\`,
+		`/data/a\b`,
+		`/data/a\$b`,
+		"/data/$b",
+		"/data/${b}",
+		filepath.Join(home, `my \ $b`),
+	} {
+		if _, stderr, status := runCaptured(t, "-l", logFile, "set", "MUSIC", path); status != 0 {
+			t.Fatalf("set %q: status %d, stderr %q", path, status, stderr)
+		}
+		stdout, stderr, status := runCaptured(t, "-l", logFile, "get", "MUSIC")
+		if status != 0 || stdout != path+"\n" || stderr != "" {
+			t.Errorf("set %q, then get: status %d, stdout %q, stderr %q", path, status, stdout, stderr)
+		}
+	}
+}
+
+// set only writes names the registry knows, so it never creates a user.dirs
+// that every later run warns about.
+func TestSetRefusesUnregisteredName(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	logFile := filepath.Join(home, "test.log")
+	userDirs := filepath.Join(home, ".config", "xdg", "user.dirs")
+
+	_, stderr, status := runCaptured(t, "-l", logFile, "set", "XDG_FOO_DIR", "/srv/foo")
+	if status == 0 || !strings.Contains(stderr, "register it in "+filepath.Join(home, ".config", "xdg", "extras.dirs")) {
+		t.Errorf("set XDG_FOO_DIR: status %d, stderr %q", status, stderr)
+	}
+	if _, err := os.Stat(userDirs); err == nil {
+		t.Error("set wrote user.dirs for an unregistered name")
+	}
+
+	os.MkdirAll(filepath.Dir(userDirs), 0755)
+	os.WriteFile(filepath.Join(home, ".config", "xdg", "extras.dirs"), []byte(`XDG_FOO_DIR="~/foo"`+"\n"), 0644)
+	for _, name := range []string{"FOO", "XDG_FOO_DIR", "projects"} {
+		if _, stderr, status := runCaptured(t, "-l", logFile, "set", name, "/srv/foo"); status != 0 {
+			t.Errorf("set %s: status %d, stderr %q", name, status, stderr)
+		}
+	}
+	if _, stderr, status := runCaptured(t, "-l", logFile, "--strict", "get", "FOO"); status != 0 {
+		t.Errorf("--strict get FOO after set: status %d, stderr %q", status, stderr)
+	}
+}
+
+// Every export writes user-dirs.dirs from user.dirs, so a restored one must
+// survive the next export instead of being overwritten by it.
+func TestRestoreSurvivesExport(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_STATE_HOME", "")
+	logFile := filepath.Join(home, "test.log")
+	export := func() {
+		t.Helper()
+		if _, stderr, status := runCaptured(t, "--shell", "posix", "-l", logFile); status != 0 {
+			t.Fatalf("export: status %d, stderr %q", status, stderr)
+		}
+	}
+	legacy := filepath.Join(home, ".config", "user-dirs.dirs")
+	os.MkdirAll(filepath.Dir(legacy), 0755)
+	os.WriteFile(legacy, []byte(`XDG_MUSIC_DIR="/data/old"`+"\n"), 0644)
+
+	export() // imports and backs up the user-dirs.dirs xdg-dirs did not write
+	runCaptured(t, "-l", logFile, "set", "MUSIC", "/data/new")
+	export()
+
+	saved, _ := filepath.Glob(filepath.Join(home, ".local", "state", "xdg-dirs", "backups", "*-user-dirs.dirs-*"))
+	if len(saved) != 1 {
+		t.Fatalf("user-dirs.dirs backups: %v", saved)
+	}
+	id := strings.TrimLeft(strings.SplitN(filepath.Base(saved[0]), "-", 2)[0], "0")
+	stdout, stderr, status := runCaptured(t, "-l", logFile, "restore", id)
+	if status != 0 || !strings.Contains(stdout, "XDG_MUSIC_DIR=/data/old") {
+		t.Fatalf("restore %s: status %d, stdout %q, stderr %q", id, status, stdout, stderr)
+	}
+	export()
+
+	if content, _ := os.ReadFile(legacy); !strings.Contains(string(content), `XDG_MUSIC_DIR="/data/old"`) {
+		t.Errorf("user-dirs.dirs after restore and export:\n%s", content)
+	}
+	if stdout, _, _ := runCaptured(t, "-l", logFile, "get", "MUSIC"); stdout != "/data/old\n" {
+		t.Errorf("get MUSIC after restore = %q", stdout)
+	}
+
+	// generated.dirs has nothing to adopt: restoring it says it won't last.
+	saved, _ = filepath.Glob(filepath.Join(home, ".local", "state", "xdg-dirs", "backups", "*-generated.dirs-*"))
+	if len(saved) == 0 {
+		t.Fatal("no generated.dirs backup")
+	}
+	id = strings.TrimLeft(strings.SplitN(filepath.Base(saved[0]), "-", 2)[0], "0")
+	if stdout, _, _ := runCaptured(t, "-l", logFile, "restore", id); !strings.Contains(stdout, "only a snapshot") {
+		t.Errorf("restore of generated.dirs: %q", stdout)
+	}
+}
@@ -0,0 +1,148 @@
+// Package apps relocates the files of programs that ignore the XDG variables
+// but honour one of their own, the way xdg-ninja recommends: CARGO_HOME,
+// GNUPGHOME, HISTFILE and so on, derived from the resolved XDG directories.
+//
+// Nothing is relocated unless the user opts in, per program, in user.dirs:
+//
+//	@apps="cargo gnupg less"
+//
+// Moving existing data is left to the user: setting CARGO_HOME does not move
+// ~/.cargo, and a program started with the new value will not find the old
+// one. The same database tells the audit what each file in $HOME belongs to,
+// including programs no variable can relocate.
+package apps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Var is one variable a rule sets. Value refers to XDG variables, e.g.
+// "$XDG_DATA_HOME/cargo". File marks variables naming a file rather than a
+// directory; Private directories are created with mode 0700.
+type Var struct {
+	Name    string
+	Value   string
+	File    bool
+	Private bool
+}
+
+// Fix is how the files of a program can be moved out of $HOME.
+type Fix string
+
+const (
+	FixEnv         Fix = "env"         // a variable, exported once the app is in @apps
+	FixConfig      Fix = "config"      // a setting in the program's own configuration
+	FixUnsupported Fix = "unsupported" // the location is hardcoded
+)
+
+// Rule is what relocating one program takes.
+type Rule struct {
+	Name   string   // what users write in @apps
+	Paths  []string // what it leaves in $HOME, relative to it; filepath.Match patterns
+	Vars   []Var
+	Config string // the setting to change, for FixConfig
+	Note   string // caveat, or why it is unsupported
+}
+
+// Fix returns the kind of fix r offers.
+func (r Rule) Fix() Fix {
+	switch {
+	case len(r.Vars) > 0:
+		return FixEnv
+	case r.Config != "":
+		return FixConfig
+	}
+	return FixUnsupported
+}
+
+// Rules returns the whole database, sorted by name.
+func Rules() []Rule {
+	return append([]Rule(nil), rules...)
+}
+
+// Export is a variable resolved for export.
+type Export struct {
+	App     string
+	Name    string
+	Value   string
+	File    bool
+	Private bool
+}
+
+// Lookup returns the rule for an app name.
+func Lookup(name string) (Rule, bool) {
+	for _, rule := range rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Names returns the apps that can be listed in @apps, those relocated with a
+// variable, sorted.
+func Names() []string {
+	var names []string
+	for _, rule := range rules {
+		if rule.Fix() == FixEnv {
+			names = append(names, rule.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve expands the variables of the named apps against dirs, the merged
+// XDG directories. The result is sorted by variable name so the exports are
+// byte-stable. Two apps setting the same variable to different values is an
+// error, as is a reference to a directory dirs does not have.
+func Resolve(names []string, dirs map[string]string) ([]Export, error) {
+	byName := make(map[string]Export)
+	for _, name := range names {
+		rule, ok := Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown app %q", name)
+		}
+		if rule.Fix() != FixEnv {
+			return nil, fmt.Errorf("%s cannot be relocated with a variable", name)
+		}
+		for _, v := range rule.Vars {
+			var missing []string
+			value := os.Expand(v.Value, func(key string) string {
+				if dirs[key] == "" {
+					missing = append(missing, key)
+				}
+				return dirs[key]
+			})
+			if len(missing) > 0 {
+				return nil, fmt.Errorf("%s: %s needs %s", name, v.Name, strings.Join(missing, ", "))
+			}
+			value = filepath.Clean(value)
+
+			if prev, ok := byName[v.Name]; ok && prev.Value != value {
+				return nil, fmt.Errorf("%s and %s both set %s", prev.App, name, v.Name)
+			}
+			byName[v.Name] = Export{App: name, Name: v.Name, Value: value, File: v.File, Private: v.Private}
+		}
+	}
+
+	exports := make([]Export, 0, len(byName))
+	for _, e := range byName {
+		exports = append(exports, e)
+	}
+	sort.Slice(exports, func(i, j int) bool { return exports[i].Name < exports[j].Name })
+	return exports, nil
+}
+
+// Dir is the directory to create for e: the value itself, or the directory
+// holding the file.
+func (e Export) Dir() string {
+	if e.File {
+		return filepath.Dir(e.Value)
+	}
+	return e.Value
+}
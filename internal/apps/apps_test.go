@@ -0,0 +1,73 @@
+package apps
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+var dirs = map[string]string{
+	"XDG_CACHE_HOME":  "/home/x/.cache",
+	"XDG_CONFIG_HOME": "/home/x/.config",
+	"XDG_DATA_HOME":   "/home/x/.local/share",
+	"XDG_STATE_HOME":  "/home/x/.local/state",
+}
+
+func TestRulesAreWellFormed(t *testing.T) {
+	names := make([]string, len(rules))
+	for i, rule := range rules {
+		names[i] = rule.Name
+		if rule.Name != strings.ToLower(rule.Name) || len(rule.Paths) == 0 {
+			t.Errorf("bad rule %+v", rule)
+		}
+		if rule.Fix() == FixUnsupported && rule.Note == "" {
+			t.Errorf("%s: unsupported without a reason", rule.Name)
+		}
+		for _, v := range rule.Vars {
+			if !strings.HasPrefix(v.Value, "$XDG_") {
+				t.Errorf("%s: %s = %q does not derive from an XDG variable", rule.Name, v.Name, v.Value)
+			}
+		}
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("rules are not sorted by name: %v", names)
+	}
+	if _, err := Resolve(Names(), dirs); err != nil {
+		t.Errorf("the whole database does not resolve: %v", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	exports, err := Resolve([]string{"npm", "cargo", "less"}, dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range exports {
+		got = append(got, e.Name+"="+e.Value)
+	}
+	want := []string{
+		"CARGO_HOME=/home/x/.local/share/cargo",
+		"LESSHISTFILE=/home/x/.local/state/less/history",
+		"NPM_CONFIG_USERCONFIG=/home/x/.config/npm/npmrc",
+		"npm_config_cache=/home/x/.cache/npm",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Resolve =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, e := range exports {
+		if e.Name == "LESSHISTFILE" && e.Dir() != "/home/x/.local/state/less" {
+			t.Errorf("LESSHISTFILE dir = %q", e.Dir())
+		}
+	}
+
+	if _, err := Resolve([]string{"cargo"}, map[string]string{}); err == nil || !strings.Contains(err.Error(), "XDG_DATA_HOME") {
+		t.Errorf("missing directory: err = %v", err)
+	}
+	if _, err := Resolve([]string{"nope"}, dirs); err == nil {
+		t.Error("unknown app resolved")
+	}
+	if _, err := Resolve([]string{"ssh"}, dirs); err == nil {
+		t.Error("app without variables resolved")
+	}
+}
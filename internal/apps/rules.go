@@ -0,0 +1,70 @@
+package apps
+
+// rules is the curated database, sorted by name. Every rule only uses
+// variables and settings the program documents; the paths follow xdg-ninja,
+// so a machine cleaned up by hand with its advice ends up with the same
+// layout.
+var rules = []Rule{
+	{Name: "bash", Paths: []string{".bash_history"}, Vars: []Var{
+		{Name: "HISTFILE", Value: "$XDG_STATE_HOME/bash/history", File: true},
+	}, Note: "HISTFILE only takes effect for shells started after the export"},
+	{Name: "cargo", Paths: []string{".cargo"}, Vars: []Var{
+		{Name: "CARGO_HOME", Value: "$XDG_DATA_HOME/cargo"},
+	}},
+	{Name: "docker", Paths: []string{".docker"}, Vars: []Var{
+		{Name: "DOCKER_CONFIG", Value: "$XDG_CONFIG_HOME/docker"},
+	}},
+	{Name: "flatpak", Paths: []string{".var/app"},
+		Note: "Flatpak keeps the data of every sandboxed app in ~/.var/app"},
+	{Name: "git", Paths: []string{".gitconfig"},
+		Config: "move it to $XDG_CONFIG_HOME/git/config, which git reads natively"},
+	{Name: "gnupg", Paths: []string{".gnupg"}, Vars: []Var{
+		{Name: "GNUPGHOME", Value: "$XDG_DATA_HOME/gnupg", Private: true},
+	}, Note: "gpg-agent sockets move too; restart the agent after switching"},
+	{Name: "go", Paths: []string{"go"}, Vars: []Var{
+		{Name: "GOPATH", Value: "$XDG_DATA_HOME/go"},
+	}},
+	{Name: "gradle", Paths: []string{".gradle"}, Vars: []Var{
+		{Name: "GRADLE_USER_HOME", Value: "$XDG_DATA_HOME/gradle"},
+	}},
+	{Name: "less", Paths: []string{".lesshst"}, Vars: []Var{
+		{Name: "LESSHISTFILE", Value: "$XDG_STATE_HOME/less/history", File: true},
+	}},
+	{Name: "mysql", Paths: []string{".mysql_history"}, Vars: []Var{
+		{Name: "MYSQL_HISTFILE", Value: "$XDG_STATE_HOME/mysql/history", File: true},
+	}},
+	{Name: "node", Paths: []string{".node_repl_history"}, Vars: []Var{
+		{Name: "NODE_REPL_HISTORY", Value: "$XDG_STATE_HOME/node/repl_history", File: true},
+	}},
+	{Name: "npm", Paths: []string{".npm", ".npmrc"}, Vars: []Var{
+		{Name: "npm_config_cache", Value: "$XDG_CACHE_HOME/npm"},
+		{Name: "NPM_CONFIG_USERCONFIG", Value: "$XDG_CONFIG_HOME/npm/npmrc", File: true},
+	}},
+	{Name: "pki", Paths: []string{".pki"},
+		Note: "NSS, used by Chromium, hardcodes ~/.pki"},
+	{Name: "psql", Paths: []string{".psql_history"}, Vars: []Var{
+		{Name: "PSQL_HISTORY", Value: "$XDG_STATE_HOME/psql/history", File: true},
+	}},
+	{Name: "python", Paths: []string{".python_history"}, Vars: []Var{
+		{Name: "PYTHON_HISTORY", Value: "$XDG_STATE_HOME/python/history", File: true},
+	}, Note: "PYTHON_HISTORY needs Python 3.13 or later"},
+	{Name: "rustup", Paths: []string{".rustup"}, Vars: []Var{
+		{Name: "RUSTUP_HOME", Value: "$XDG_DATA_HOME/rustup"},
+	}},
+	{Name: "sqlite", Paths: []string{".sqlite_history"}, Vars: []Var{
+		{Name: "SQLITE_HISTORY", Value: "$XDG_STATE_HOME/sqlite/history", File: true},
+	}},
+	{Name: "ssh", Paths: []string{".ssh"},
+		Note: "OpenSSH hardcodes ~/.ssh"},
+	{Name: "tmux", Paths: []string{".tmux.conf"},
+		Config: "move it to $XDG_CONFIG_HOME/tmux/tmux.conf, read natively since tmux 3.1"},
+	{Name: "vim", Paths: []string{".vim", ".vimrc", ".viminfo"},
+		Config: "move .vim and .vimrc to $XDG_CONFIG_HOME/vim (Vim 9.1 and later) and set viminfofile=$XDG_STATE_HOME/vim/viminfo"},
+	{Name: "wget", Paths: []string{".wget-hsts"},
+		Config: "run wget with --hsts-file=$XDG_CACHE_HOME/wget-hsts, e.g. from an alias"},
+	{Name: "wine", Paths: []string{".wine"}, Vars: []Var{
+		{Name: "WINEPREFIX", Value: "$XDG_DATA_HOME/wine/prefixes/default"},
+	}},
+	{Name: "zsh", Paths: []string{".zsh_history", ".zcompdump*"},
+		Config: "in .zshrc, set HISTFILE=$XDG_STATE_HOME/zsh/history and call compinit -d $XDG_CACHE_HOME/zsh/zcompdump"},
+}
@@ -0,0 +1,146 @@
+// Package audit finds the files programs leave in $HOME instead of the XDG
+// directories and tells, from the apps database, who owns each one and how
+// to move it.
+package audit
+
+// Rationale:
+// Exporting the right variables is half of a clean $HOME; the other half is
+// knowing what is still there. The scan only looks at names starting with a
+// dot at the top of $HOME (plus the few rule paths that are not dotfiles,
+// like ~/go) and goes down into dot-directories up to Depth levels, never
+// into the user's own folders or the XDG directories themselves. A matched
+// directory is not entered: ~/.npm is one finding, not thousands.
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adriangalilea/xdg-dirs/internal/apps"
+)
+
+// DefaultDepth is how many levels below $HOME are looked at.
+const DefaultDepth = 2
+
+// Options tune a scan.
+type Options struct {
+	Depth    int      // levels below $HOME; DefaultDepth if 0
+	Skip     []string // directories not to enter, e.g. XDG_CONFIG_HOME
+	Selected []string // apps already in @apps
+}
+
+// Finding is one file or directory in $HOME that a rule claims.
+type Finding struct {
+	Path     string   `json:"path"`
+	App      string   `json:"app"`
+	Fix      apps.Fix `json:"fix"`
+	Vars     []string `json:"vars,omitempty"` // NAME=value, unexpanded
+	Hint     string   `json:"hint,omitempty"`
+	Selected bool     `json:"selected"` // the app is in @apps: what is left is old data
+}
+
+// String describes f in one line, with home shown as ~.
+func (f Finding) String(home string) string {
+	p := f.Path
+	if rel, err := filepath.Rel(home, p); err == nil && !strings.HasPrefix(rel, "..") {
+		p = "~/" + filepath.ToSlash(rel)
+	}
+	var fix string
+	switch f.Fix {
+	case apps.FixEnv:
+		fix = "env " + strings.Join(f.Vars, " ")
+		if f.Selected {
+			fix += " (in @apps: move the existing data there)"
+		} else {
+			fix += fmt.Sprintf(" (add %s to @apps)", f.App)
+		}
+	case apps.FixConfig:
+		fix = "config: " + f.Hint
+	default:
+		fix = "unsupported: " + f.Hint
+	}
+	return fmt.Sprintf("%s: %s: %s", p, f.App, fix)
+}
+
+// Scan walks home and returns what the rules match, sorted by path.
+func Scan(home string, opts Options) ([]Finding, error) {
+	depth := opts.Depth
+	if depth <= 0 {
+		depth = DefaultDepth
+	}
+	skip := make(map[string]bool, len(opts.Skip))
+	for _, dir := range opts.Skip {
+		skip[filepath.Clean(dir)] = true
+	}
+	selected := make(map[string]bool, len(opts.Selected))
+	for _, name := range opts.Selected {
+		selected[name] = true
+	}
+	rules := apps.Rules()
+
+	var findings []Finding
+	var walk func(rel string, level int) error
+	walk = func(rel string, level int) error {
+		entries, err := os.ReadDir(filepath.Join(home, filepath.FromSlash(rel)))
+		if err != nil {
+			if rel == "" {
+				return fmt.Errorf("failed to read %s: %w", home, err)
+			}
+			return nil // unreadable subdirectories are not worth failing for
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			p := path.Join(rel, name)
+			if rule, ok := match(rules, p); ok {
+				findings = append(findings, newFinding(filepath.Join(home, filepath.FromSlash(p)), rule, selected[rule.Name]))
+				continue
+			}
+			if !entry.IsDir() || level >= depth || (level == 1 && !strings.HasPrefix(name, ".")) {
+				continue
+			}
+			if skip[filepath.Join(home, filepath.FromSlash(p))] {
+				continue
+			}
+			if err := walk(p, level+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("", 1); err != nil {
+		return nil, err
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Path < findings[j].Path })
+	return findings, nil
+}
+
+// match returns the rule claiming p, a slash-separated path relative to
+// $HOME.
+func match(rules []apps.Rule, p string) (apps.Rule, bool) {
+	for _, rule := range rules {
+		for _, pattern := range rule.Paths {
+			if ok, _ := path.Match(pattern, p); ok {
+				return rule, true
+			}
+		}
+	}
+	return apps.Rule{}, false
+}
+
+func newFinding(p string, rule apps.Rule, selected bool) Finding {
+	f := Finding{Path: p, App: rule.Name, Fix: rule.Fix(), Selected: selected}
+	switch f.Fix {
+	case apps.FixEnv:
+		for _, v := range rule.Vars {
+			f.Vars = append(f.Vars, v.Name+"="+v.Value)
+		}
+	case apps.FixConfig:
+		f.Hint = rule.Config
+	default:
+		f.Hint = rule.Note
+	}
+	return f
+}
@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adriangalilea/xdg-dirs/internal/apps"
+)
+
+// The fixture home has one file for every kind of fix, plus files that must
+// not be reported: unknown dotfiles, the insides of a matched directory and
+// anything in a folder that is not a dot-directory.
+func TestScanFixtureHome(t *testing.T) {
+	home, err := filepath.Abs(filepath.Join("testdata", "home"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := Scan(home, Options{Selected: []string{"cargo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.String(home))
+	}
+	want := []string{
+		"~/.bash_history: bash: env HISTFILE=$XDG_STATE_HOME/bash/history (add bash to @apps)",
+		"~/.cargo: cargo: env CARGO_HOME=$XDG_DATA_HOME/cargo (in @apps: move the existing data there)",
+		"~/.gitconfig: git: config: move it to $XDG_CONFIG_HOME/git/config, which git reads natively",
+		"~/.gnupg: gnupg: env GNUPGHOME=$XDG_DATA_HOME/gnupg (add gnupg to @apps)",
+		"~/.npm: npm: env npm_config_cache=$XDG_CACHE_HOME/npm NPM_CONFIG_USERCONFIG=$XDG_CONFIG_HOME/npm/npmrc (add npm to @apps)",
+		"~/.npmrc: npm: env npm_config_cache=$XDG_CACHE_HOME/npm NPM_CONFIG_USERCONFIG=$XDG_CONFIG_HOME/npm/npmrc (add npm to @apps)",
+		"~/.ssh: ssh: unsupported: OpenSSH hardcodes ~/.ssh",
+		"~/.var/app: flatpak: unsupported: Flatpak keeps the data of every sandboxed app in ~/.var/app",
+		"~/.zcompdump-host-5.9: zsh: config: in .zshrc, set HISTFILE=$XDG_STATE_HOME/zsh/history and call compinit -d $XDG_CACHE_HOME/zsh/zcompdump",
+		"~/go: go: env GOPATH=$XDG_DATA_HOME/go (add go to @apps)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Scan =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	out, err := json.Marshal(findings[6])
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON := `{"path":"` + filepath.Join(home, ".ssh") + `","app":"ssh","fix":"unsupported","hint":"OpenSSH hardcodes ~/.ssh","selected":false}`
+	if string(out) != wantJSON {
+		t.Errorf("JSON = %s, want %s", out, wantJSON)
+	}
+}
+
+func TestScanDepth(t *testing.T) {
+	home := filepath.Join("testdata", "home")
+	findings, err := Scan(home, Options{Depth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings {
+		if f.App == "flatpak" {
+			t.Errorf("depth 1 reached %s", f.Path)
+		}
+	}
+
+	findings, err = Scan(home, Options{Skip: []string{filepath.Join(home, ".var")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings {
+		if f.App == "flatpak" {
+			t.Errorf("skipped directory entered: %s", f.Path)
+		}
+	}
+}
+
+// Every path in the database must be a valid pattern, or it never matches.
+func TestRulePaths(t *testing.T) {
+	for _, rule := range apps.Rules() {
+		for _, pattern := range rule.Paths {
+			if _, err := filepath.Match(pattern, ""); err != nil || strings.HasPrefix(pattern, "/") {
+				t.Errorf("%s: bad path %q", rule.Name, pattern)
+			}
+		}
+	}
+}
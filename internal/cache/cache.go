@@ -0,0 +1,183 @@
+// Package cache keeps the exports of the last run, keyed by a fingerprint of
+// everything they depend on, so a shell start with nothing changed only reads.
+package cache
+
+// Rationale:
+// Every shell start runs xdg-dirs, and each run used to re-merge, rewrite
+// generated.dirs and user-dirs.dirs and touch the log, though the inputs had
+// rarely changed since the last one. The result only depends on a few
+// things: the files xdg-dirs reads and writes, the environment variables
+// they refer to, the options, the binary and the platform. Their
+// fingerprint names a cache entry holding the exports; when it exists (and
+// the directories the entry says must exist still do), the exports are
+// printed and nothing is written. Anything the fingerprint misses can be
+// forced with --no-cache.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// Retention is how many entries are kept; older ones are removed when a new
+// one is stored. Two are typically in use: login shells, which inherit no
+// XDG variables, and their children, which inherit the exports.
+const Retention = 8
+
+// Entry is what a run leaves for the next identical one.
+type Entry struct {
+	Exports string   `json:"exports"`
+	Dirs    []string `json:"dirs,omitempty"` // must still exist for the entry to be used
+}
+
+// reference matches $NAME and ${NAME...} in the fingerprinted files.
+var reference = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Fingerprint hashes the inputs of a run: the content of files (a missing
+// file counts as such), env, the variables any of the files refers to, the
+// extra strings (options), and the binary and platform.
+func Fingerprint(files []string, env map[string]string, extra ...string) string {
+	h := sha256.New()
+	referenced := make(map[string]bool)
+	for _, path := range files {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(h, "file %s missing\x00", path)
+			continue
+		}
+		fmt.Fprintf(h, "file %s %d\x00", path, len(content))
+		h.Write(content)
+		for _, m := range reference.FindAllSubmatch(content, -1) {
+			referenced[string(m[1])] = true
+		}
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "env %s=%s\x00", name, env[name])
+	}
+	names = names[:0]
+	for name := range referenced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, ok := os.LookupEnv(name)
+		fmt.Fprintf(h, "ref %s=%s %t\x00", name, value, ok)
+	}
+
+	for _, s := range extra {
+		fmt.Fprintf(h, "opt %s\x00", s)
+	}
+	io.WriteString(h, binaryID())
+	fmt.Fprintf(h, "platform %s/%s", runtime.GOOS, runtime.GOARCH)
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// binaryID identifies the running build: its module version and VCS revision
+// when known, and the size and modification time of the executable, which
+// also tell apart development builds.
+func binaryID() string {
+	id := "binary"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		id += " " + info.Main.Version
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" || s.Key == "vcs.modified" {
+				id += " " + s.Value
+			}
+		}
+	}
+	if exe, err := os.Executable(); err == nil {
+		if fi, err := os.Stat(exe); err == nil {
+			id += fmt.Sprintf(" %d %d", fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return id + "\x00"
+}
+
+// Load returns the entry stored in dir under fingerprint, if there is one
+// whose directories all exist.
+func Load(dir, fingerprint string) (Entry, bool) {
+	content, err := os.ReadFile(filepath.Join(dir, fingerprint))
+	if err != nil {
+		return Entry{}, false
+	}
+	var e Entry
+	if err := json.Unmarshal(content, &e); err != nil {
+		return Entry{}, false
+	}
+	for _, d := range e.Dirs {
+		if fi, err := os.Stat(d); err != nil || !fi.IsDir() {
+			return Entry{}, false
+		}
+	}
+	return e, true
+}
+
+// Store saves e in dir under fingerprint, atomically, and removes all but the
+// Retention most recent entries.
+func Store(dir, fingerprint string, e Entry) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	content, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once the rename succeeded
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, fingerprint)); err != nil {
+		return err
+	}
+	prune(dir)
+	return nil
+}
+
+// prune removes the oldest entries beyond Retention. Failures are ignored: a
+// stale entry is only wasted space.
+func prune(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type aged struct {
+		path string
+		mod  time.Time
+	}
+	var all []aged
+	for _, entry := range entries {
+		if entry.Name()[0] == '.' {
+			continue
+		}
+		if fi, err := entry.Info(); err == nil {
+			all = append(all, aged{filepath.Join(dir, entry.Name()), fi.ModTime()})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].mod.After(all[j].mod) })
+	for i := Retention; i < len(all); i++ {
+		os.Remove(all[i].path)
+	}
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	userDirs := filepath.Join(dir, "user.dirs")
+	missing := filepath.Join(dir, "generated.dirs")
+	os.WriteFile(userDirs, []byte("XDG_MUSIC_DIR=\"$MEDIA/music\"\n"), 0644)
+	os.Setenv("MEDIA", "/media")
+	defer os.Unsetenv("MEDIA")
+
+	files := []string{userDirs, missing}
+	env := map[string]string{"HOME": "/home/x"}
+	base := Fingerprint(files, env, "shell=posix")
+	if again := Fingerprint(files, env, "shell=posix"); again != base {
+		t.Fatalf("fingerprint is not stable: %s, %s", base, again)
+	}
+
+	changes := map[string]func(){
+		"inherited env":  func() { env["XDG_RUNTIME_DIR"] = "/run/user/1000" },
+		"referenced env": func() { os.Setenv("MEDIA", "/mnt") },
+		"file content":   func() { os.WriteFile(userDirs, []byte("XDG_MUSIC_DIR=\"$MEDIA/Music\"\n"), 0644) },
+		"empty file":     func() { os.WriteFile(missing, nil, 0644) },
+	}
+	for _, name := range []string{"inherited env", "referenced env", "file content", "empty file"} {
+		before := Fingerprint(files, env, "shell=posix")
+		changes[name]()
+		if Fingerprint(files, env, "shell=posix") == before {
+			t.Errorf("%s does not change the fingerprint", name)
+		}
+	}
+	if Fingerprint(files, env, "shell=fish") == Fingerprint(files, env, "shell=posix") {
+		t.Error("options do not change the fingerprint")
+	}
+
+	before := Fingerprint(files, env, "shell=posix")
+	os.Setenv("UNRELATED", "1")
+	defer os.Unsetenv("UNRELATED")
+	if Fingerprint(files, env, "shell=posix") != before {
+		t.Error("an unrelated variable changes the fingerprint")
+	}
+}
+
+func TestLoadStore(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	music := filepath.Join(dir, "Music")
+	os.Mkdir(music, 0755)
+
+	if _, ok := Load(cacheDir, "abc"); ok {
+		t.Fatal("hit in an empty cache")
+	}
+	want := Entry{Exports: "export XDG_MUSIC_DIR='" + music + "'", Dirs: []string{music}}
+	if err := Store(cacheDir, "abc", want); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := Load(cacheDir, "abc")
+	if !ok || got.Exports != want.Exports {
+		t.Fatalf("Load = %+v, %v", got, ok)
+	}
+
+	os.Remove(music)
+	if _, ok := Load(cacheDir, "abc"); ok {
+		t.Error("entry used although one of its directories is gone")
+	}
+
+	for i := 0; i < Retention+3; i++ {
+		if err := Store(cacheDir, string(rune('a'+i))+"x", Entry{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, _ := os.ReadDir(cacheDir)
+	if len(entries) != Retention {
+		t.Errorf("%d entries kept, want %d", len(entries), Retention)
+	}
+}
+
+func BenchmarkFingerprint(b *testing.B) {
+	dir := b.TempDir()
+	var files []string
+	for _, name := range []string{"user.dirs", "extras.dirs", "generated.dirs", "user-dirs.dirs"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte("XDG_DOWNLOAD_DIR=\"$HOME/dl\"\nXDG_MUSIC_DIR=\"/data/music\"\n"), 0644)
+		files = append(files, path)
+	}
+	env := map[string]string{"HOME": "/home/x", "XDG_RUNTIME_DIR": "/run/user/1000"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Fingerprint(files, env, "shell=posix", "strict=false")
+	}
+}
@@ -0,0 +1,173 @@
+package cli
+
+// Rationale:
+// Bare `xdg-dirs` (with or without options) has to keep meaning "print the
+// exports", because that is what every existing `eval "$(xdg-dirs)"` line
+// runs. So the App has a default command: global options are parsed first,
+// and if what follows is not a command name, the whole argument list goes to
+// the default command. Everything except the exports goes to stderr.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Command is one subcommand.
+type Command struct {
+	Name        string
+	Aliases     []string
+	Args        string // positional arguments, as shown in the usage line
+	Summary     string // one line, for the command list
+	Description string // optional longer text for the command's own help
+	Flags       *FlagSet
+	Run         func(args []string) int
+}
+
+// App dispatches to subcommands.
+type App struct {
+	Name    string
+	Help    string // top-level help text
+	Global  *FlagSet
+	Default string // command run when none is named
+	// Before runs after option parsing and before the command, e.g. to set
+	// up logging once the global options are known. An error aborts the run
+	// with status 1.
+	Before   func() error
+	Commands []*Command
+
+	Stderr io.Writer
+	Stdout io.Writer
+
+	help bool
+}
+
+func (a *App) stdout() io.Writer {
+	if a.Stdout != nil {
+		return a.Stdout
+	}
+	return os.Stdout
+}
+
+func (a *App) stderr() io.Writer {
+	if a.Stderr != nil {
+		return a.Stderr
+	}
+	return os.Stderr
+}
+
+func (a *App) lookup(name string) *Command {
+	for _, c := range a.Commands {
+		if c.Name == name {
+			return c
+		}
+		for _, alias := range c.Aliases {
+			if alias == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// Run parses args (without the program name) and runs the selected command,
+// returning its exit status. Usage errors return 2.
+func (a *App) Run(args []string) int {
+	a.help = false
+	global := &FlagSet{}
+	global.Bool(&a.help, "h", "help", "Show help")
+	global = global.merged(a.Global)
+
+	cmd := a.lookup(a.Default)
+	rest, err := global.Parse(args, false)
+	var unknown errUnknownFlag
+	switch {
+	case errors.As(err, &unknown):
+		// An option only the default command knows, e.g. `xdg-dirs -c`.
+		rest = args
+	case err != nil:
+		return a.usageError(nil, err)
+	case len(rest) > 0 && rest[0] == "help":
+		return a.runHelp(rest[1:])
+	case len(rest) > 0:
+		if named := a.lookup(rest[0]); named != nil {
+			cmd, rest = named, rest[1:]
+		}
+	case a.help:
+		fmt.Fprintln(a.stdout(), a.Help)
+		return 0
+	}
+
+	positional, err := global.merged(cmd.Flags).Parse(rest, true)
+	if err != nil {
+		return a.usageError(cmd, err)
+	}
+	if a.help {
+		fmt.Fprintln(a.stdout(), a.CommandHelp(cmd))
+		return 0
+	}
+	if cmd.Name == a.Default && len(positional) > 0 {
+		return a.usageError(nil, fmt.Errorf("unknown command %q", positional[0]))
+	}
+
+	if a.Before != nil {
+		if err := a.Before(); err != nil {
+			fmt.Fprintf(a.stderr(), "%s: %v\n", a.Name, err)
+			return 1
+		}
+	}
+	return cmd.Run(positional)
+}
+
+func (a *App) runHelp(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(a.stdout(), a.Help)
+		return 0
+	}
+	cmd := a.lookup(args[0])
+	if cmd == nil {
+		return a.usageError(nil, fmt.Errorf("unknown command %q", args[0]))
+	}
+	fmt.Fprintln(a.stdout(), a.CommandHelp(cmd))
+	return 0
+}
+
+func (a *App) usageError(cmd *Command, err error) int {
+	hint := a.Name + " --help"
+	if cmd != nil {
+		hint = a.Name + " help " + cmd.Name
+	}
+	fmt.Fprintf(a.stderr(), "%s: %v\nTry '%s' for more information.\n", a.Name, err, hint)
+	return 2
+}
+
+// CommandHelp renders the help of one command, global options included.
+func (a *App) CommandHelp(cmd *Command) string {
+	var b strings.Builder
+	usage := a.Name
+	if cmd.Name != a.Default {
+		usage += " " + cmd.Name
+	} else {
+		usage += " [" + cmd.Name + "]"
+	}
+	usage += " [options]"
+	if cmd.Args != "" {
+		usage += " " + cmd.Args
+	}
+	fmt.Fprintf(&b, "Usage: %s\n\n%s\n", usage, cmd.Summary)
+	if cmd.Description != "" {
+		fmt.Fprintf(&b, "\n%s\n", cmd.Description)
+	}
+	if len(cmd.Aliases) > 0 {
+		fmt.Fprintf(&b, "\nAliases: %s\n", strings.Join(cmd.Aliases, ", "))
+	}
+	if cmd.Flags != nil && len(cmd.Flags.flags) > 0 {
+		fmt.Fprintf(&b, "\nOptions:\n%s\n", cmd.Flags.Help())
+	}
+	global := &FlagSet{}
+	global.Bool(new(bool), "h", "help", "Show help")
+	fmt.Fprintf(&b, "\nGlobal options:\n%s", global.merged(a.Global).Help())
+	return b.String()
+}
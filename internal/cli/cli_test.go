@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseGNUForms(t *testing.T) {
+	var debug, dryRun bool
+	var logFile, shell string
+	fs := &FlagSet{}
+	fs.Bool(&debug, "d", "debug", "")
+	fs.Bool(&dryRun, "n", "dry-run", "")
+	fs.String(&logFile, "l", "log-file", "PATH", "")
+	fs.String(&shell, "s", "shell", "NAME", "")
+
+	cases := []struct {
+		args       []string
+		debug, dry bool
+		logFile    string
+		shell      string
+		positional []string
+	}{
+		{[]string{"--debug", "--dry-run"}, true, true, "", "", nil},
+		{[]string{"-dn"}, true, true, "", "", nil},
+		{[]string{"-dl", "/x.log"}, true, false, "/x.log", "", nil},
+		{[]string{"-l/x.log", "a"}, false, false, "/x.log", "", []string{"a"}},
+		{[]string{"--log-file=/x.log", "--shell", "fish"}, false, false, "/x.log", "fish", nil},
+		{[]string{"a", "--debug", "b"}, true, false, "", "", []string{"a", "b"}},
+		{[]string{"--debug=false", "--", "-n"}, false, false, "", "", []string{"-n"}},
+	}
+	for _, c := range cases {
+		debug, dryRun, logFile, shell = false, false, "", ""
+		positional, err := fs.Parse(c.args, true)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.args, err)
+		}
+		if debug != c.debug || dryRun != c.dry || logFile != c.logFile || shell != c.shell || !reflect.DeepEqual(positional, c.positional) {
+			t.Errorf("Parse(%q) = debug %v, dry-run %v, log-file %q, shell %q, args %q", c.args, debug, dryRun, logFile, shell, positional)
+		}
+	}
+
+	for _, args := range [][]string{{"--nope"}, {"-x"}, {"--log-file"}, {"--debug=maybe"}} {
+		if _, err := fs.Parse(args, true); err == nil {
+			t.Errorf("Parse(%q) succeeded", args)
+		}
+	}
+}
+
+func TestAppDispatch(t *testing.T) {
+	var debug, dryRun bool
+	var ran string
+	var gotArgs []string
+	record := func(name string) func([]string) int {
+		return func(args []string) int {
+			ran, gotArgs = name, args
+			return 0
+		}
+	}
+	global := &FlagSet{}
+	global.Bool(&debug, "d", "debug", "")
+	exportFlags := &FlagSet{}
+	exportFlags.Bool(&dryRun, "n", "dry-run", "")
+
+	var stderr bytes.Buffer
+	app := &App{
+		Name:    "xdg-dirs",
+		Global:  global,
+		Default: "export",
+		Stderr:  &stderr,
+		Stdout:  &bytes.Buffer{},
+		Commands: []*Command{
+			{Name: "export", Flags: exportFlags, Run: record("export")},
+			{Name: "get", Run: record("get")},
+			{Name: "list", Aliases: []string{"ls"}, Run: record("list")},
+		},
+	}
+
+	cases := []struct {
+		args   []string
+		status int
+		ran    string
+		rest   []string
+	}{
+		{nil, 0, "export", nil},
+		{[]string{"-dn"}, 0, "export", nil},
+		{[]string{"-d", "get", "DOWNLOAD"}, 0, "get", []string{"DOWNLOAD"}},
+		{[]string{"get", "--debug", "DOWNLOAD"}, 0, "get", []string{"DOWNLOAD"}},
+		{[]string{"ls"}, 0, "list", nil},
+		{[]string{"bogus"}, 2, "", nil},
+		{[]string{"get", "-n", "X"}, 2, "", nil},
+		{[]string{"--help"}, 0, "", nil},
+		{[]string{"help", "get"}, 0, "", nil},
+	}
+	for _, c := range cases {
+		ran, gotArgs = "", nil
+		status := app.Run(c.args)
+		if status != c.status || ran != c.ran || !reflect.DeepEqual(gotArgs, c.rest) {
+			t.Errorf("Run(%q) = %d running %q with %q; want %d running %q with %q",
+				c.args, status, ran, gotArgs, c.status, c.ran, c.rest)
+		}
+	}
+}
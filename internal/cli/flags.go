@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Flag is one option with an optional short and a long spelling.
+type Flag struct {
+	Short string // single letter, without the dash; may be empty
+	Long  string // without the dashes
+	Arg   string // placeholder shown in help for options taking a value
+	Usage string
+
+	boolPtr   *bool
+	stringPtr *string
+}
+
+func (f *Flag) takesValue() bool { return f.stringPtr != nil }
+
+func (f *Flag) set(value string) error {
+	if f.stringPtr != nil {
+		*f.stringPtr = value
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("option --%s expects true or false, got %q", f.Long, value)
+	}
+	*f.boolPtr = b
+	return nil
+}
+
+// FlagSet holds the options of one command. Parsing follows GNU conventions:
+// -d, -dn (grouped), -l PATH, -lPATH, --log-file PATH, --log-file=PATH,
+// --debug=false, and -- to end option parsing.
+type FlagSet struct {
+	flags []*Flag
+}
+
+// Bool registers a boolean option.
+func (fs *FlagSet) Bool(p *bool, short, long, usage string) {
+	fs.flags = append(fs.flags, &Flag{Short: short, Long: long, Usage: usage, boolPtr: p})
+}
+
+// String registers an option taking a value; arg names it in the help.
+func (fs *FlagSet) String(p *string, short, long, arg, usage string) {
+	fs.flags = append(fs.flags, &Flag{Short: short, Long: long, Arg: arg, Usage: usage, stringPtr: p})
+}
+
+// merged returns a set holding the options of fs followed by those of more.
+func (fs *FlagSet) merged(more ...*FlagSet) *FlagSet {
+	out := &FlagSet{flags: append([]*Flag(nil), fs.flags...)}
+	for _, m := range more {
+		if m != nil {
+			out.flags = append(out.flags, m.flags...)
+		}
+	}
+	return out
+}
+
+func (fs *FlagSet) short(name string) *Flag {
+	for _, f := range fs.flags {
+		if f.Short != "" && f.Short == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (fs *FlagSet) long(name string) *Flag {
+	for _, f := range fs.flags {
+		if f.Long == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// errUnknownFlag is returned for options the set does not define.
+type errUnknownFlag struct{ name string }
+
+func (e errUnknownFlag) Error() string { return fmt.Sprintf("unknown option %s", e.name) }
+
+// Parse sets the options found in args and returns the positional arguments.
+// With interspersed, options and positionals may be mixed; otherwise parsing
+// stops at the first positional and everything from it on is returned.
+func (fs *FlagSet) Parse(args []string, interspersed bool) ([]string, error) {
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(positional, args[i+1:]...), nil
+
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			f := fs.long(name)
+			if f == nil {
+				return nil, errUnknownFlag{"--" + name}
+			}
+			if f.takesValue() && !hasValue {
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("option --%s requires a value", name)
+				}
+				i++
+				value, hasValue = args[i], true
+			}
+			if !hasValue {
+				value = "true"
+			}
+			if err := f.set(value); err != nil {
+				return nil, err
+			}
+
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			// A group of short options; the first one taking a value
+			// consumes the rest of the group or the next argument.
+			group := arg[1:]
+			for j := 0; j < len(group); j++ {
+				name := group[j : j+1]
+				f := fs.short(name)
+				if f == nil {
+					return nil, errUnknownFlag{"-" + name}
+				}
+				if !f.takesValue() {
+					*f.boolPtr = true
+					continue
+				}
+				value := group[j+1:]
+				if value == "" {
+					if i+1 >= len(args) {
+						return nil, fmt.Errorf("option -%s requires a value", name)
+					}
+					i++
+					value = args[i]
+				}
+				if err := f.set(value); err != nil {
+					return nil, err
+				}
+				break
+			}
+
+		default:
+			if !interspersed {
+				return append(positional, args[i:]...), nil
+			}
+			positional = append(positional, arg)
+		}
+	}
+	return positional, nil
+}
+
+// Help renders the option list, one option per line.
+func (fs *FlagSet) Help() string {
+	var b strings.Builder
+	for _, f := range fs.flags {
+		spelled := "    "
+		if f.Short != "" {
+			spelled = "-" + f.Short + ", "
+		}
+		spelled += "--" + f.Long
+		if f.Arg != "" {
+			spelled += " " + f.Arg
+		}
+		fmt.Fprintf(&b, "  %-26s %s\n", spelled, f.Usage)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/adriangalilea/xdg-dirs/internal/shell"
 )
 
 var (
@@ -22,21 +25,58 @@ func init() {
 	HelpMessage = fmt.Sprintf(`xdg-dirs: A cross-platform tool for managing XDG user directories
 
 Usage:
-xdg-dirs [options]
+xdg-dirs [command] [options]
+
+Commands:
+  export             Write generated.dirs and print the exports (default)
+  get <NAME>         Print one directory (DOWNLOAD, CACHE or XDG_DOWNLOAD_DIR)
+  set <NAME> <PATH>  Set a directory in user.dirs
+  unset <NAME>       Remove a directory from user.dirs (the default applies again)
+  list               Print every resolved directory
+  explain [NAME...]  Show where each directory comes from (--json for JSON)
+  check              Report problems with the directories (alias doctor, --json)
+  import             Copy the directories of user-dirs.dirs into user.dirs
+                     (--dry-run to preview)
+  backups [list]     List the saved versions of user-dirs.dirs and generated.dirs
+  restore <ID>       Put a saved version back in place
+  audit              List files in $HOME that belong in the XDG directories
+                     (--json, --depth N)
+  help [command]     Show help for a command
 
 Options:
-  -d, --debug        Enable debug output
+  -d, --debug        Enable debug output (on stderr, never stdout)
+      --debug-fd FD  Send debug output to file descriptor FD instead of stderr
   -n, --dry-run      Simulate changes without applying them
   -c, --create-dirs  Create directories if they don't exist
   -l, --log-file     Specify the log file path (default: %s)
+      --log-format   Log format: text (default) or json, one object per line
+      --log-max-size     Rotate the log at this size (default: 10M)
+      --log-max-backups  Rotated logs to keep, 0 keeps all (default: 5)
+      --log-max-age      Delete rotated logs older than this, e.g. 30d (default: 30d)
+      --log-compress     Gzip rotated logs
+      --runtime-fallback  Create $TMPDIR/xdg-runtime-$UID (mode 0700) when
+                     XDG_RUNTIME_DIR is missing or invalid
+      --no-cache     Merge and write even when nothing changed since the
+                     last run
+  -s, --shell        Shell syntax for the exports (default: auto):
+                     %s or auto
+  -p, --precedence   Source order for every variable: env, user, default
+                     (default: user,default; XDG_RUNTIME_DIR: env,user,default)
+      --strict       Treat any problem found in user.dirs as fatal
   -h, --help         Show help message
 
 Configuration:
   This tool looks for ~/.config/xdg/user.dirs
 
 Info:
-  This tool moves the ~/.config/user-dirs.dirs (deprecated from xdg-user-dirs and xdg-user-dirs-update) into ~/.config/xdg/user-dirs.dirs-backup
-  This tool generates the ~/.config/xdg/user.dirs file.
+  This tool writes user-dirs.dirs in the merged XDG_CONFIG_HOME (by default
+  ~/.config) from the merged result, for GTK and other xdg-user-dirs
+  readers. One it did not write is first backed up, after its directories
+  are imported into user.dirs (once).
+  Replaced versions of user-dirs.dirs and generated.dirs are kept under
+  $XDG_STATE_HOME/xdg-dirs/backups, in the exported XDG_STATE_HOME (by default
+  ~/.local/state; see backups and restore).
+  This tool generates the ~/.config/xdg/generated.dirs file.
 
-For more detailed information, please refer to the README.md file.`, DefaultLogFilePath)
+For more detailed information, please refer to the README.md file.`, DefaultLogFilePath, strings.Join(shell.Supported(), ", "))
 }
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package doctor
+
+import "os"
+
+// writable tries to create a file in dir, the only portable way to know.
+func writable(dir string) bool {
+	f, err := os.CreateTemp(dir, ".xdg-dirs-check-*")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
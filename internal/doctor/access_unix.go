@@ -0,0 +1,12 @@
+//go:build unix
+
+package doctor
+
+import "syscall"
+
+// writable asks the kernel whether the current user may create files in dir,
+// which also accounts for ACLs and read-only mounts.
+func writable(dir string) bool {
+	const wOK = 0x2 // W_OK from unistd.h
+	return syscall.Access(dir, wOK) == nil
+}
@@ -0,0 +1,233 @@
+// Package doctor inspects the merged directories and the files behind them
+// and reports everything that looks wrong, without changing anything.
+//
+// Errors are values no program can use as the spec intends: relative or
+// unexpanded paths, files where directories should be, directories that
+// cannot be written. Warnings are probably unintended but harmless until
+// something needs the directory: a missing directory, two variables sharing
+// one target, a suspicious line in user.dirs, a generated.dirs behind
+// user.dirs. Syntax errors in user.dirs are errors: the line is ignored.
+package doctor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adriangalilea/xdg-dirs/internal/runtimedir"
+	"github.com/adriangalilea/xdg-dirs/internal/xdgdirs"
+)
+
+// Severity ranks a finding.
+type Severity string
+
+const (
+	Warning Severity = "warning"
+	Error   Severity = "error"
+)
+
+// Finding is one problem. Path, Line and Column locate it when it comes from
+// a file line or a directory on disk.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Key      string   `json:"key,omitempty"`
+	Path     string   `json:"path,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	var b strings.Builder
+	b.WriteString(string(f.Severity) + ": ")
+	switch {
+	case f.Column > 0:
+		fmt.Fprintf(&b, "%s:%d:%d: ", f.Path, f.Line, f.Column)
+	case f.Line > 0:
+		fmt.Fprintf(&b, "%s:%d: ", f.Path, f.Line)
+	}
+	if f.Key != "" {
+		b.WriteString(f.Key + ": ")
+	}
+	b.WriteString(f.Message)
+	return b.String()
+}
+
+// Input is everything the checks look at.
+type Input struct {
+	Dirs          []xdgdirs.Origin     // merged values, sorted by key
+	Diagnostics   []xdgdirs.Diagnostic // from parsing user.dirs
+	Strict        bool                 // report every diagnostic as an error
+	GeneratedPath string
+	Generated     map[string]string // nil if generated.dirs does not exist
+}
+
+// Run performs every check. Findings come in a fixed order: values, shared
+// targets, user.dirs, generated.dirs.
+func Run(in Input) []Finding {
+	var findings []Finding
+	for _, origin := range in.Dirs {
+		findings = append(findings, checkValue(origin)...)
+	}
+	findings = append(findings, checkDuplicates(in.Dirs)...)
+	for _, d := range in.Diagnostics {
+		severity := Warning
+		if d.Severity == xdgdirs.SeverityError || in.Strict {
+			severity = Error
+		}
+		findings = append(findings, Finding{
+			Severity: severity, Path: d.File, Line: d.Line, Column: d.Column, Message: d.Message,
+		})
+	}
+	if f, ok := checkGenerated(in); ok {
+		findings = append(findings, f)
+	}
+	return findings
+}
+
+// Count returns the number of errors and warnings.
+func Count(findings []Finding) (errors, warnings int) {
+	for _, f := range findings {
+		if f.Severity == Error {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+	return errors, warnings
+}
+
+func checkValue(origin xdgdirs.Origin) []Finding {
+	at := func(severity Severity, path, format string, v ...interface{}) Finding {
+		f := Finding{Severity: severity, Key: origin.Key, Path: path, Message: fmt.Sprintf(format, v...)}
+		if origin.Source == xdgdirs.SourceUser {
+			f.Path, f.Line = origin.File, origin.Line
+		}
+		return f
+	}
+
+	if xdgdirs.IsSearchPath(origin.Key) {
+		var findings []Finding
+		for _, entry := range strings.Split(origin.Value, ":") {
+			if problem := pathProblem(entry); problem != "" {
+				findings = append(findings, at(Error, entry, "search path entry %s", problem))
+			}
+		}
+		return findings
+	}
+	if problem := pathProblem(origin.Value); problem != "" {
+		return []Finding{at(Error, origin.Value, "%s", problem)}
+	}
+
+	if origin.Key == "XDG_RUNTIME_DIR" {
+		report := runtimedir.Check(origin.Value)
+		var findings []Finding
+		for _, problem := range report.Errors {
+			findings = append(findings, at(Error, origin.Value, "%s violates the spec: %s", origin.Value, problem))
+		}
+		for _, warning := range report.Warnings {
+			findings = append(findings, at(Warning, origin.Value, "%s is %s", origin.Value, warning))
+		}
+		return findings
+	}
+
+	info, err := os.Stat(origin.Value)
+	switch {
+	case os.IsNotExist(err):
+		return []Finding{at(Warning, origin.Value, "%s does not exist (xdg-dirs -c creates it)", origin.Value)}
+	case err != nil:
+		return []Finding{at(Error, origin.Value, "cannot stat %s: %v", origin.Value, err)}
+	case !info.IsDir():
+		return []Finding{at(Error, origin.Value, "%s is not a directory", origin.Value)}
+	case !writable(origin.Value):
+		return []Finding{at(Error, origin.Value, "%s is not writable", origin.Value)}
+	}
+	return nil
+}
+
+// pathProblem explains why value cannot be used as a directory as is, or
+// returns "".
+func pathProblem(value string) string {
+	switch {
+	case strings.Contains(value, "$") || strings.HasPrefix(value, "~"):
+		return fmt.Sprintf("%q was not expanded", value)
+	case !filepath.IsAbs(value):
+		return fmt.Sprintf("%q is not an absolute path", value)
+	}
+	return ""
+}
+
+// checkDuplicates reports directories shared by more than one variable. The
+// search paths are lists and may overlap freely.
+func checkDuplicates(dirs []xdgdirs.Origin) []Finding {
+	byTarget := make(map[string][]string)
+	for _, origin := range dirs {
+		if xdgdirs.IsSearchPath(origin.Key) || !filepath.IsAbs(origin.Value) {
+			continue
+		}
+		target := filepath.Clean(origin.Value)
+		byTarget[target] = append(byTarget[target], origin.Key)
+	}
+	targets := make([]string, 0, len(byTarget))
+	for target, keys := range byTarget {
+		if len(keys) > 1 {
+			targets = append(targets, target)
+		}
+	}
+	sort.Strings(targets)
+
+	var findings []Finding
+	for _, target := range targets {
+		keys := byTarget[target]
+		sort.Strings(keys)
+		findings = append(findings, Finding{
+			Severity: Warning, Key: keys[0], Path: target,
+			Message: fmt.Sprintf("%s is also used by %s", target, strings.Join(keys[1:], ", ")),
+		})
+	}
+	return findings
+}
+
+// checkGenerated compares generated.dirs with the merge. XDG_RUNTIME_DIR is
+// left out: it belongs to the session, and --runtime-fallback may have
+// replaced it.
+func checkGenerated(in Input) (Finding, bool) {
+	if in.Generated == nil {
+		return Finding{
+			Severity: Warning, Path: in.GeneratedPath,
+			Message: fmt.Sprintf("%s does not exist yet; run xdg-dirs to write it", in.GeneratedPath),
+		}, true
+	}
+
+	current := make(map[string]string, len(in.Dirs))
+	for _, origin := range in.Dirs {
+		current[origin.Key] = origin.Value
+	}
+	stale := make(map[string]bool)
+	for key, value := range current {
+		if in.Generated[key] != value {
+			stale[key] = true
+		}
+	}
+	for key := range in.Generated {
+		if _, ok := current[key]; !ok {
+			stale[key] = true
+		}
+	}
+	delete(stale, "XDG_RUNTIME_DIR")
+	if len(stale) == 0 {
+		return Finding{}, false
+	}
+
+	keys := make([]string, 0, len(stale))
+	for key := range stale {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return Finding{
+		Severity: Warning, Path: in.GeneratedPath,
+		Message: fmt.Sprintf("%s is out of date for %s; run xdg-dirs to regenerate it", in.GeneratedPath, strings.Join(keys, ", ")),
+	}, true
+}
@@ -0,0 +1,103 @@
+package doctor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adriangalilea/xdg-dirs/internal/xdgdirs"
+)
+
+func dir(key, value string) xdgdirs.Origin {
+	return xdgdirs.Origin{Key: key, Value: value, Source: xdgdirs.SourceDefault, Raw: value}
+}
+
+// find returns the findings about key whose message mentions text.
+func find(findings []Finding, key, text string) []Finding {
+	var found []Finding
+	for _, f := range findings {
+		if f.Key == key && strings.Contains(f.Message, text) {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+func TestRun(t *testing.T) {
+	home := t.TempDir()
+	os.Mkdir(filepath.Join(home, "Music"), 0755)
+	os.WriteFile(filepath.Join(home, "Desktop"), nil, 0644)
+	userDirs := filepath.Join(home, "user.dirs")
+
+	in := Input{
+		Dirs: []xdgdirs.Origin{
+			{Key: "XDG_CACHE_HOME", Value: "cache", Source: xdgdirs.SourceUser, File: userDirs, Line: 3, Raw: "cache"},
+			dir("XDG_CONFIG_DIRS", "/etc/xdg:$HOME/etc"),
+			dir("XDG_DATA_HOME", "~/.local/share"),
+			dir("XDG_DESKTOP_DIR", filepath.Join(home, "Desktop")),
+			dir("XDG_DOWNLOAD_DIR", filepath.Join(home, "Music")),
+			dir("XDG_MUSIC_DIR", filepath.Join(home, "Music")+"/"),
+			dir("XDG_VIDEOS_DIR", filepath.Join(home, "Videos")),
+		},
+		Diagnostics: []xdgdirs.Diagnostic{
+			{File: userDirs, Line: 4, Column: 1, Severity: xdgdirs.SeverityWarning, Message: "unknown variable XDG_CAHCE_HOME"},
+		},
+		GeneratedPath: filepath.Join(home, "generated.dirs"),
+		Generated:     map[string]string{"XDG_VIDEOS_DIR": "/old/videos", "XDG_RUNTIME_DIR": "/run/user/1"},
+	}
+	findings := Run(in)
+
+	cases := []struct {
+		key, text string
+		severity  Severity
+	}{
+		{"XDG_CACHE_HOME", "not an absolute path", Error},
+		{"XDG_CONFIG_DIRS", "was not expanded", Error},
+		{"XDG_DATA_HOME", "was not expanded", Error},
+		{"XDG_DESKTOP_DIR", "is not a directory", Error},
+		{"XDG_VIDEOS_DIR", "does not exist", Warning},
+		{"XDG_DOWNLOAD_DIR", "also used by XDG_MUSIC_DIR", Warning},
+		{"", "unknown variable XDG_CAHCE_HOME", Warning},
+		{"", "out of date for XDG_CACHE_HOME, XDG_CONFIG_DIRS", Warning},
+	}
+	for _, c := range cases {
+		found := find(findings, c.key, c.text)
+		if len(found) != 1 || found[0].Severity != c.severity {
+			t.Errorf("want one %s about %s %q, got %v", c.severity, c.key, c.text, found)
+		}
+	}
+	if f := find(findings, "XDG_CACHE_HOME", "absolute"); len(f) == 1 && f[0].Line != 3 {
+		t.Errorf("user.dirs finding not located at line 3: %+v", f[0])
+	}
+	if f := find(findings, "", "out of date"); len(f) == 1 && strings.Contains(f[0].Message, "XDG_RUNTIME_DIR") {
+		t.Errorf("XDG_RUNTIME_DIR reported as stale: %s", f[0].Message)
+	}
+
+	errors, warnings := Count(findings)
+	if errors != 4 || warnings != 4 {
+		t.Errorf("Count = %d errors, %d warnings; findings:\n%v", errors, warnings, findings)
+	}
+
+	in.Strict = true
+	if f := find(Run(in), "", "unknown variable"); len(f) != 1 || f[0].Severity != Error {
+		t.Errorf("strict mode did not make the diagnostic an error: %v", f)
+	}
+}
+
+func TestRunClean(t *testing.T) {
+	home := t.TempDir()
+	music := filepath.Join(home, "Music")
+	os.Mkdir(music, 0755)
+	in := Input{
+		Dirs:      []xdgdirs.Origin{dir("XDG_MUSIC_DIR", music)},
+		Generated: map[string]string{"XDG_MUSIC_DIR": music},
+	}
+	if findings := Run(in); len(findings) != 0 {
+		t.Errorf("clean setup reported %v", findings)
+	}
+	in.Generated = nil
+	if findings := Run(in); len(findings) != 1 || !strings.Contains(findings[0].Message, "does not exist yet") {
+		t.Errorf("missing generated.dirs not reported: %v", findings)
+	}
+}
@@ -0,0 +1,47 @@
+// Package fslock provides advisory, inter-process file locks.
+//
+// Many shells can start at the same moment (tmux restoring a dozen panes), and
+// each runs xdg-dirs. Anything that rewrites or renames a shared file takes
+// one of these locks first, so the processes take turns instead of racing.
+package fslock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Lock is a held lock on a lock file.
+type Lock struct {
+	file *os.File
+}
+
+// Acquire blocks until it holds an exclusive lock on path, creating the file
+// (and its directory) if needed. The lock file itself is never removed:
+// deleting it would let two processes lock two different files.
+func Acquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lock(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return &Lock{file: f}, nil
+}
+
+// Release gives the lock up. It is safe to call on a nil Lock.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	err := unlock(l.file)
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
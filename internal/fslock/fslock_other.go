@@ -0,0 +1,9 @@
+//go:build !unix
+
+package fslock
+
+import "os"
+
+// Only Linux and macOS are supported targets; elsewhere locking is a no-op.
+func lock(f *os.File) error   { return nil }
+func unlock(f *os.File) error { return nil }
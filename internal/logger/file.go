@@ -0,0 +1,96 @@
+package logger
+
+// Rationale:
+// Every new shell creates a logger, and most runs never log anything. So the
+// log file is only created and opened by the first write: the fast path does
+// no MkdirAll and no open, which matters on slow NFS homes. If the file can't
+// be written (read-only home, full disk), the process must still print its
+// exports, so messages fall back to stderr instead of killing it.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// lazyFile is an append-only log file opened on first Write.
+type lazyFile struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	failed   bool
+	fallback io.Writer
+}
+
+func (f *lazyFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil && !f.failed {
+		if err := f.open(); err != nil {
+			f.failed = true
+			if f.fallback != nil {
+				fmt.Fprintf(f.fallback, "xdg-dirs: cannot write log file %s, logging to stderr: %v\n", f.path, err)
+			}
+		}
+	}
+	if f.file == nil {
+		if f.fallback == nil {
+			return len(p), nil
+		}
+		return f.fallback.Write(p)
+	}
+	return f.file.Write(p)
+}
+
+func (f *lazyFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	return nil
+}
+
+// setFallback sets where messages go when the file cannot be opened.
+func (f *lazyFile) setFallback(w io.Writer) {
+	f.mu.Lock()
+	f.fallback = w
+	f.mu.Unlock()
+}
+
+// closeForReopen closes the file, if open, so the next Write opens whatever
+// is at the path now (used after rotation).
+func (f *lazyFile) closeForReopen() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// reopenIfMoved closes the file if it is no longer the one at the path.
+func (f *lazyFile) reopenIfMoved(atPath os.FileInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return
+	}
+	if open, err := f.file.Stat(); err != nil || !os.SameFile(open, atPath) {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// unwritable reports whether opening the file already failed.
+func (f *lazyFile) unwritable() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failed
+}
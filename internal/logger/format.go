@@ -0,0 +1,112 @@
+package logger
+
+// Rationale:
+// The text format is for people reading the log; the JSON format is for log
+// pipelines. In JSON mode every message is exactly one line holding one
+// object, with the fields attached through With as top-level keys, so a
+// pipeline never has to parse the message text to find the variable, path or
+// error it is about.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log formats accepted by SetFormat.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// SetFormat selects the text (default) or JSON log format. It applies to the
+// log file and to debug output alike.
+func (l *Logger) SetFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q (want %s or %s)", format, FormatText, FormatJSON)
+	}
+	l.mu.Lock()
+	l.format = format
+	l.mu.Unlock()
+	return nil
+}
+
+// With returns a logger that attaches the given key/value pairs to every
+// message, e.g. log.With("key", key, "path", dir).Error("Failed to create directory").
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{sink: l.sink, fields: fields}
+}
+
+// emit writes one message with the logger's fields. Text goes through the
+// level's log.Logger; JSON is written to the level's writer in one Write so
+// lines from concurrent processes appending to the file never interleave.
+func (l *Logger) emit(level string, textLogger *log.Logger, w io.Writer, message string) {
+	if l.format != FormatJSON {
+		textLogger.Print(message + l.textFields())
+		return
+	}
+	w.Write(l.jsonLine(level, message))
+}
+
+func (l *Logger) textFields() string {
+	var b strings.Builder
+	l.eachField(func(key string, value interface{}) {
+		text := fmt.Sprint(value)
+		if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+			text = strconv.Quote(text)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, text)
+	})
+	return b.String()
+}
+
+func (l *Logger) jsonLine(level, message string) []byte {
+	var b strings.Builder
+	b.WriteString(`{"timestamp":`)
+	writeJSON(&b, time.Now().Format("2006-01-02T15:04:05.000Z07:00"))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, level)
+	b.WriteString(`,"message":`)
+	writeJSON(&b, message)
+	l.eachField(func(key string, value interface{}) {
+		b.WriteByte(',')
+		writeJSON(&b, key)
+		b.WriteByte(':')
+		writeJSON(&b, value)
+	})
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+// eachField walks the key/value pairs in order. Errors are reported by their
+// message, and a trailing key without a value is reported under !BADKEY.
+func (l *Logger) eachField(fn func(key string, value interface{})) {
+	for i := 0; i < len(l.fields); i += 2 {
+		if i+1 == len(l.fields) {
+			fn("!BADKEY", l.fields[i])
+			return
+		}
+		value := l.fields[i+1]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		fn(fmt.Sprint(l.fields[i]), value)
+	}
+}
+
+func writeJSON(b *strings.Builder, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(data)
+}
@@ -4,36 +4,46 @@ package logger
 // The tool is designed to be evaluated by the shell, not to modify its own environment.
 // This means every single output from the binary should be silent unless `-debug` is specified,
 // as any single output will be `eval`'d (executed). Therefore, we only output log.Export(exports)
-// to stdout and log all other messages to ~/xdg.log unless in debug mode.
+// to stdout and log all other messages to the log file. In debug mode messages are also shown on
+// stderr (or the writer given to SetDiagnostics), NEVER stdout: `eval "$(xdg-dirs -d)"` must
+// still only execute exports.
 
 import (
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"sync"
-	"time"
 
 	"github.com/adriangalilea/xdg-dirs/internal/conf"
 	"github.com/charmbracelet/lipgloss"
 )
 
-const maxLogFileSize = 10 * 1024 * 1024 // 10 MB
-
 var (
 	timestampStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("39"))
 	messageStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("82"))
 )
 
+// Logger writes to the log file, to stderr in debug mode, and exports to
+// stdout. Loggers derived with With share everything but their fields.
 type Logger struct {
+	*sink
+	fields []interface{}
+}
+
+type sink struct {
 	debug        bool
-	logFile      *os.File
+	format       string
+	logFile      *lazyFile
 	logFilePath  string
 	infoLogger   *log.Logger
 	debugLogger  *log.Logger
+	warnLogger   *log.Logger
 	errorLogger  *log.Logger
 	exportLogger *log.Logger
+	diagnostics  io.Writer
+	retention    Retention
+	pruned       bool // rotated logs were pruned by this process
 	mu           sync.RWMutex
 	writers      struct {
 		info   io.Writer
@@ -43,45 +53,40 @@ type Logger struct {
 	}
 }
 
+// NewLogger never touches the disk: the log file is created and opened by
+// the first message written to it (see lazyFile).
 func NewLogger(debug bool, logFilePath string) *Logger {
-	var logFile *os.File
-	var err error
-
 	if logFilePath == "" {
 		logFilePath = conf.DefaultLogFilePath
 	}
 
-	// Ensure the directory exists
-	err = os.MkdirAll(filepath.Dir(logFilePath), 0755)
-	if err != nil {
-		log.Fatalf("Failed to create log directory: %v", err)
-	}
-
-	logFile, err = os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
-	}
-
-	logger := &Logger{
+	logger := &Logger{sink: &sink{
 		debug:        debug,
-		logFile:      logFile,
+		format:       FormatText,
+		logFile:      &lazyFile{path: logFilePath},
 		logFilePath:  logFilePath,
 		exportLogger: log.New(os.Stdout, "", 0),
-	}
+		diagnostics:  os.Stderr,
+		retention:    DefaultRetention,
+	}}
 
 	logger.updateWriters()
 
 	return logger
 }
 
-func (l *Logger) updateWriters() {
+func (l *sink) updateWriters() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	l.setWriters()
+}
 
+// setWriters rebuilds the per-level writers; l.mu must be held.
+func (l *sink) setWriters() {
 	if l.debug {
-		l.writers.info = io.MultiWriter(os.Stdout, l.logFile)
-		l.writers.debug = io.MultiWriter(os.Stdout, l.logFile)
-		l.writers.error = io.MultiWriter(os.Stderr, l.logFile)
+		l.writers.info = io.MultiWriter(l.diagnostics, l.logFile)
+		l.writers.debug = io.MultiWriter(l.diagnostics, l.logFile)
+		l.writers.error = io.MultiWriter(l.diagnostics, l.logFile)
 	} else {
 		l.writers.info = l.logFile
 		l.writers.debug = l.logFile
@@ -89,80 +94,67 @@ func (l *Logger) updateWriters() {
 	}
 	l.writers.export = os.Stdout
 
+	// If the log file turns out to be unwritable, its messages go to the
+	// diagnostics stream instead, unless debug mode already shows them there.
+	if l.debug {
+		l.logFile.setFallback(io.Discard)
+	} else {
+		l.logFile.setFallback(l.diagnostics)
+	}
+
 	l.infoLogger = log.New(l.writers.info, "INFO: ", log.Ldate|log.Ltime)
 	l.debugLogger = log.New(l.writers.debug, "DEBUG: ", log.Ldate|log.Ltime)
+	l.warnLogger = log.New(l.writers.error, "WARN: ", log.Ldate|log.Ltime)
 	l.errorLogger = log.New(l.writers.error, "ERROR: ", log.Ldate|log.Ltime)
 }
 
-func (l *Logger) rotateLogFile() error {
-	l.mu.RLock()
-	fileInfo, err := l.logFile.Stat()
-	l.mu.RUnlock()
-
-	if err != nil {
-		return fmt.Errorf("failed to get log file info: %w", err)
-	}
-
-	if fileInfo.Size() < maxLogFileSize {
-		return nil
-	}
-
+// SetDiagnostics sets where debug-mode console output goes instead of stderr,
+// e.g. a file descriptor chosen by the user. Stdout is reserved for Export.
+func (l *Logger) SetDiagnostics(w io.Writer) {
 	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	backupPath := fmt.Sprintf("%s.%s", l.logFilePath, time.Now().Format("20060102T150405"))
-	if err := os.Rename(l.logFilePath, backupPath); err != nil {
-		return fmt.Errorf("failed to rotate log file: %w", err)
-	}
-
-	l.logFile.Close()
-	l.logFile, err = os.OpenFile(l.logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open new log file: %w", err)
-	}
-
+	l.diagnostics = w
+	l.mu.Unlock()
 	l.updateWriters()
-
-	return nil
 }
 
 func (l *Logger) Info(format string, v ...interface{}) {
+	l.rotate()
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	if err := l.rotateLogFile(); err != nil {
-		l.errorLogger.Printf("Failed to rotate log file: %v", err)
-	}
-	l.infoLogger.Printf(format, v...)
+	l.emit("INFO", l.infoLogger, l.writers.info, fmt.Sprintf(format, v...))
 }
 
 func (l *Logger) Debug(format string, v ...interface{}) {
 	if l.debug {
+		l.rotate()
 		l.mu.RLock()
 		defer l.mu.RUnlock()
-		if err := l.rotateLogFile(); err != nil {
-			l.errorLogger.Printf("Failed to rotate log file: %v", err)
+		message := fmt.Sprintf(format, v...)
+		if l.format == FormatText {
+			message = messageStyle.Render(message)
 		}
-		message := messageStyle.Render(fmt.Sprintf(format, v...))
-		l.debugLogger.Print(message)
+		l.emit("DEBUG", l.debugLogger, l.writers.debug, message)
 	}
 }
 
-func (l *Logger) Error(format string, v ...interface{}) {
+// Warn records something wrong that does not stop the run.
+func (l *Logger) Warn(format string, v ...interface{}) {
+	l.rotate()
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	if err := l.rotateLogFile(); err != nil {
-		l.errorLogger.Printf("Failed to rotate log file: %v", err)
-	}
-	l.errorLogger.Printf(format, v...)
+	l.emit("WARN", l.warnLogger, l.writers.error, fmt.Sprintf(format, v...))
 }
 
-func (l *Logger) Fatal(format string, v ...interface{}) {
+func (l *Logger) Error(format string, v ...interface{}) {
+	l.rotate()
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	if err := l.rotateLogFile(); err != nil {
-		l.errorLogger.Printf("Failed to rotate log file: %v", err)
-	}
-	l.errorLogger.Fatalf(format, v...)
+	l.emit("ERROR", l.errorLogger, l.writers.error, fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Fatal(format string, v ...interface{}) {
+	l.Error(format, v...)
+	os.Exit(1)
 }
 
 func (l *Logger) Export(format string, v ...interface{}) {
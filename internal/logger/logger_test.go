@@ -0,0 +1,176 @@
+package logger
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJSONFormatOneObjectPerLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	log := NewLogger(false, path)
+	if err := log.SetFormat(FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	log.Info("plain message")
+	log.With("key", "XDG_CACHE_HOME", "path", "/home/x/.cache", "error", errors.New("boom")).Error("Failed to create directory")
+
+	content, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 lines, got %d:\n%s", len(lines), content)
+	}
+
+	var entry map[string]string
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("line is not a JSON object: %v\n%s", err, lines[1])
+	}
+	want := map[string]string{
+		"level":   "ERROR",
+		"message": "Failed to create directory",
+		"key":     "XDG_CACHE_HOME",
+		"path":    "/home/x/.cache",
+		"error":   "boom",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s = %q, want %q", k, entry[k], v)
+		}
+	}
+	if entry["timestamp"] == "" {
+		t.Error("timestamp missing")
+	}
+}
+
+func TestTextFormatAppendsFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	log := NewLogger(false, path)
+
+	log.With("key", "XDG_MUSIC_DIR", "path", "/home/x/my music").Info("Set directory")
+
+	content, _ := os.ReadFile(path)
+	if !strings.Contains(string(content), `INFO: `) ||
+		!strings.Contains(string(content), `Set directory key=XDG_MUSIC_DIR path="/home/x/my music"`) {
+		t.Errorf("unexpected text line: %s", content)
+	}
+}
+
+// Several processes sharing one log (simulated by independent loggers, each
+// with its own file descriptor) must rotate without losing the limit on
+// backups, and compressed backups must be valid gzip.
+func TestConcurrentRotationKeepsRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			log := NewLogger(false, path)
+			log.SetRetention(Retention{MaxSize: 2048, MaxBackups: 3, Compress: true})
+			for j := 0; j < 200; j++ {
+				log.Info("writer %d line %d %s", i, j, strings.Repeat("x", 40))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	backups, _ := filepath.Glob(path + ".*.gz")
+	if len(backups) == 0 || len(backups) > 3 {
+		t.Fatalf("want 1..3 rotated logs, got %d: %v", len(backups), backups)
+	}
+	if leftovers, _ := filepath.Glob(path + ".2*[0-9]"); len(leftovers) > 0 {
+		t.Errorf("uncompressed rotated logs left behind: %v", leftovers)
+	}
+	for _, backup := range backups {
+		f, err := os.Open(backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s is not gzip: %v", backup, err)
+		}
+		if _, err := io.ReadAll(zr); err != nil {
+			t.Fatalf("%s is corrupt: %v", backup, err)
+		}
+		f.Close()
+	}
+}
+
+// A log that stays under the size limit never rotates, so expired backups
+// must be pruned when a run first opens it.
+func TestExpiredBackupsPrunedOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	os.WriteFile(path, []byte("small\n"), 0644)
+	old := path + "." + time.Now().Add(-48*time.Hour).Format(backupTimeFormat)
+	recent := path + "." + time.Now().Add(-time.Hour).Format(backupTimeFormat)
+	for _, backup := range []string{old, recent} {
+		os.WriteFile(backup, []byte("rotated\n"), 0644)
+	}
+	stale := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(old, stale, stale)
+
+	log := NewLogger(false, path)
+	log.SetRetention(Retention{MaxSize: 1 << 20, MaxAge: 24 * time.Hour})
+	log.Info("one line")
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expired backup kept: %v", err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent backup removed: %v", err)
+	}
+}
+
+func TestParseSizeAndAge(t *testing.T) {
+	sizes := map[string]int64{"10M": 10 << 20, "512k": 512 << 10, "1GB": 1 << 30, "100": 100}
+	for in, want := range sizes {
+		if got, err := ParseSize(in); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if got, err := ParseAge("30d"); err != nil || got != 30*24*time.Hour {
+		t.Errorf("ParseAge(30d) = %v, %v", got, err)
+	}
+	if _, err := ParseSize("lots"); err == nil {
+		t.Error("ParseSize accepted garbage")
+	}
+}
+
+// Creating a logger must not touch the disk, and an unwritable log location
+// must degrade to stderr instead of exiting.
+func TestLazyLoggerFallsBackToStderr(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state", "test.log")
+
+	log := NewLogger(false, path)
+	log.Debug("not in debug mode, must not open anything")
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Fatalf("logger touched the disk before the first write: %v", err)
+	}
+
+	// A regular file where the log directory should be makes it unwritable
+	// even for root.
+	os.WriteFile(filepath.Join(dir, "state"), nil, 0644)
+	var stderr strings.Builder
+	log.SetDiagnostics(&stderr)
+	log.Error("something failed")
+	log.Error("and again")
+
+	if !strings.Contains(stderr.String(), "cannot write log file") ||
+		strings.Count(stderr.String(), "cannot write log file") != 1 {
+		t.Errorf("fallback notice missing or repeated:\n%s", stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "something failed") || !strings.Contains(stderr.String(), "and again") {
+		t.Errorf("messages not redirected to stderr:\n%s", stderr.String())
+	}
+}
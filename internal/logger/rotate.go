@@ -0,0 +1,248 @@
+package logger
+
+// Rationale:
+// Several processes share one log, and more than one may find it over the
+// size limit. Each takes an advisory lock next to the log (see fslock), then
+// re-checks the size of the file at the log path: if another process rotated
+// while it waited, it just reopens the fresh file instead of rotating that
+// one too.
+// Pruning and compression happen under the same lock. Pruning also runs once
+// when a process first opens the log: a small log may go months without
+// rotating, and its expired backups must still go.
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adriangalilea/xdg-dirs/internal/fslock"
+)
+
+// Retention controls when the log is rotated and which rotated logs are kept.
+type Retention struct {
+	MaxSize    int64         // rotate once the log reaches this many bytes
+	MaxBackups int           // rotated logs to keep; 0 keeps all
+	MaxAge     time.Duration // delete rotated logs older than this; 0 keeps all
+	Compress   bool          // gzip rotated logs
+}
+
+// DefaultRetention rotates at 10 MB and keeps five rotated logs for 30 days.
+var DefaultRetention = Retention{
+	MaxSize:    10 * 1024 * 1024,
+	MaxBackups: 5,
+	MaxAge:     30 * 24 * time.Hour,
+}
+
+const backupTimeFormat = "20060102T150405.000"
+
+// SetRetention replaces DefaultRetention for this logger.
+func (l *Logger) SetRetention(r Retention) {
+	l.mu.Lock()
+	l.retention = r
+	l.mu.Unlock()
+}
+
+// rotate rotates the log if needed, reporting failures through the logger
+// itself. It must be called without l.mu held.
+func (l *Logger) rotate() {
+	if err := l.rotateLogFile(); err != nil {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		l.emit("ERROR", l.errorLogger, l.writers.error, fmt.Sprintf("Failed to rotate log file: %v", err))
+	}
+}
+
+func (l *sink) rotateLogFile() error {
+	l.mu.RLock()
+	maxSize := l.retention.MaxSize
+	pruned := l.pruned
+	l.mu.RUnlock()
+
+	if l.logFile.unwritable() {
+		return nil
+	}
+	if !pruned {
+		if err := l.pruneOnOpen(); err != nil {
+			return err
+		}
+	}
+	if maxSize <= 0 {
+		return nil
+	}
+	// Stat the path rather than our descriptor: the file may not be open
+	// yet, and a run that logs a single line must still rotate a full log.
+	fileInfo, err := os.Stat(l.logFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get log file info: %w", err)
+	}
+	if fileInfo.Size() < maxSize {
+		// Another process may have rotated the file we have open.
+		l.logFile.reopenIfMoved(fileInfo)
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, err := fslock.Acquire(l.logFilePath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	// Only rotate if nobody else did while we waited for the lock.
+	current, err := os.Stat(l.logFilePath)
+	if err == nil && current.Size() >= maxSize {
+		backupPath := l.logFilePath + "." + time.Now().Format(backupTimeFormat)
+		if err := os.Rename(l.logFilePath, backupPath); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+		if l.retention.Compress {
+			if err := compressFile(backupPath); err != nil {
+				return fmt.Errorf("failed to compress %s: %w", backupPath, err)
+			}
+		}
+	}
+
+	// Whoever rotated, our descriptor may point at a backup now.
+	l.logFile.closeForReopen()
+
+	return l.pruneBackups()
+}
+
+// pruneOnOpen prunes rotated logs before this process first writes the log.
+// Without backups there is nothing to prune, and no lock file is created.
+func (l *sink) pruneOnOpen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pruned {
+		return nil
+	}
+	l.pruned = true
+
+	if backups, err := filepath.Glob(l.logFilePath + ".2*"); err != nil || len(backups) == 0 {
+		return err
+	}
+	lock, err := fslock.Acquire(l.logFilePath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return l.pruneBackups()
+}
+
+// pruneBackups deletes rotated logs beyond MaxBackups or older than MaxAge.
+// l.mu and the rotation lock must be held.
+func (l *sink) pruneBackups() error {
+	backups, err := filepath.Glob(l.logFilePath + ".*")
+	if err != nil {
+		return err
+	}
+	var rotated []string
+	for _, path := range backups {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(path, l.logFilePath+"."), ".gz")
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			rotated = append(rotated, path)
+		} else if _, err := time.Parse("20060102T150405", stamp); err == nil {
+			rotated = append(rotated, path) // named by older versions
+		}
+	}
+	// Timestamps sort lexicographically: newest last.
+	sort.Strings(rotated)
+
+	var firstErr error
+	for i, path := range rotated {
+		remove := l.retention.MaxBackups > 0 && i < len(rotated)-l.retention.MaxBackups
+		if !remove && l.retention.MaxAge > 0 {
+			if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > l.retention.MaxAge {
+				remove = true
+			}
+		}
+		if remove {
+			if err := os.Remove(path); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("failed to remove old log %s: %w", path, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// compressFile replaces path with path.gz.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	return os.Remove(path)
+}
+
+// ParseSize reads a size such as 10M, 512K, 1G or a plain byte count.
+func ParseSize(s string) (int64, error) {
+	text := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	multiplier := int64(1)
+	if text != "" {
+		switch text[len(text)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			text = text[:len(text)-1]
+		}
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// ParseAge reads a duration, additionally accepting whole days such as 30d.
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,17 @@
+package runtimedir
+
+import "syscall"
+
+// Filesystems that live in memory and vanish on reboot.
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+func isVolatile(path string) (volatile, known bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false, false
+	}
+	return st.Type == tmpfsMagic || st.Type == ramfsMagic, true
+}
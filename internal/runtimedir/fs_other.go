@@ -0,0 +1,7 @@
+//go:build !linux
+
+package runtimedir
+
+// Outside Linux there is no cheap, reliable way to tell; macOS for instance
+// has no tmpfs and cleans $TMPDIR itself.
+func isVolatile(path string) (volatile, known bool) { return false, false }
@@ -0,0 +1,90 @@
+// Package runtimedir checks XDG_RUNTIME_DIR against the spec and provides a
+// fallback for sessions without one.
+//
+// The spec says the system provides XDG_RUNTIME_DIR (logind on most Linux
+// desktops): a directory owned by the user, mode 0700, on a volatile
+// filesystem that is cleaned up when the user logs out. Containers and SSH
+// sessions without logind often have none, and hand-rolled values in
+// user.dirs are easy to get wrong, so this package validates whatever we got
+// and, only when asked to, creates a per-user directory under $TMPDIR.
+package runtimedir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Report is the outcome of checking one runtime directory. Errors are spec
+// violations; Warnings are allowed by the spec but probably unintended.
+type Report struct {
+	Path     string
+	Errors   []string
+	Warnings []string
+}
+
+// OK reports whether the directory satisfies the spec.
+func (r Report) OK() bool { return len(r.Errors) == 0 }
+
+// Check validates path as an XDG_RUNTIME_DIR for the current user.
+func Check(path string) Report {
+	r := Report{Path: path}
+	if !filepath.IsAbs(path) {
+		r.Errors = append(r.Errors, "path is not absolute")
+		return r
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		r.Errors = append(r.Errors, fmt.Sprintf("cannot stat: %v", err))
+		return r
+	}
+	if !info.IsDir() {
+		r.Errors = append(r.Errors, "not a directory")
+		return r
+	}
+	if uid, ok := ownerOf(info); ok && uid != os.Getuid() {
+		r.Errors = append(r.Errors, fmt.Sprintf("owned by uid %d, not by the current user (uid %d)", uid, os.Getuid()))
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		r.Errors = append(r.Errors, fmt.Sprintf("mode is %04o, the spec requires 0700", perm))
+	}
+	if volatile, known := isVolatile(path); known && !volatile {
+		r.Warnings = append(r.Warnings, "on a persistent filesystem; the spec expects it to be removed at logout")
+	}
+	return r
+}
+
+// FallbackPath is where EnsureFallback creates the runtime directory:
+// $TMPDIR/xdg-runtime-$UID.
+func FallbackPath() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("xdg-runtime-%d", os.Getuid()))
+}
+
+// EnsureFallback creates FallbackPath with mode 0700, or adopts it if it
+// already exists and belongs to the current user. It refuses symlinks and
+// directories owned by someone else, since $TMPDIR is often shared.
+func EnsureFallback() (string, error) {
+	path := FallbackPath()
+	if err := os.Mkdir(path, 0700); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("failed to create %s: %w", path, err)
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s exists and is not a directory (or is a symlink)", path)
+	}
+	if uid, ok := ownerOf(info); ok && uid != os.Getuid() {
+		return "", fmt.Errorf("%s is owned by uid %d, refusing to use it", path, uid)
+	}
+	// Mkdir is subject to the umask, and an existing directory may have been
+	// loosened since.
+	if info.Mode().Perm() != 0700 {
+		if err := os.Chmod(path, 0700); err != nil {
+			return "", fmt.Errorf("failed to set mode 0700 on %s: %w", path, err)
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,57 @@
+package runtimedir
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckMode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run")
+	os.Mkdir(dir, 0700)
+	os.Chmod(dir, 0700)
+	if r := Check(dir); !r.OK() {
+		t.Fatalf("0700 dir owned by us rejected: %v", r.Errors)
+	}
+
+	os.Chmod(dir, 0755)
+	r := Check(dir)
+	if r.OK() || !strings.Contains(strings.Join(r.Errors, "\n"), "0755") {
+		t.Errorf("0755 dir accepted or not explained: %v", r.Errors)
+	}
+
+	if r := Check("relative/run"); r.OK() {
+		t.Error("relative path accepted")
+	}
+	if r := Check(filepath.Join(dir, "missing")); r.OK() {
+		t.Error("missing directory accepted")
+	}
+}
+
+func TestEnsureFallback(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	// A loosened leftover is tightened again.
+	os.Mkdir(FallbackPath(), 0777)
+	os.Chmod(FallbackPath(), 0777)
+
+	path, err := EnsureFallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != tmp {
+		t.Errorf("fallback %s is not under TMPDIR %s", path, tmp)
+	}
+	if r := Check(path); !r.OK() {
+		t.Errorf("fallback fails its own check: %v", r.Errors)
+	}
+
+	// A symlink planted at the fallback path must be refused.
+	os.Remove(path)
+	os.Symlink(t.TempDir(), path)
+	if _, err := EnsureFallback(); err == nil {
+		t.Error("symlinked fallback accepted")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package runtimedir
+
+import "os"
+
+func ownerOf(info os.FileInfo) (int, bool) { return 0, false }
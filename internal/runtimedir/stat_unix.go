@@ -0,0 +1,16 @@
+//go:build unix
+
+package runtimedir
+
+import (
+	"os"
+	"syscall"
+)
+
+func ownerOf(info os.FileInfo) (int, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(st.Uid), true
+}
@@ -2,65 +2,46 @@ package setup
 
 import (
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/adriangalilea/xdg-dirs/internal/logger"
 )
 
-// Prepare performs initial setup tasks like unsetting environment variables
-// and backing up the user-dirs.dirs file.
-func Prepare(log *logger.Logger) error {
-	if err := unsetXDGEnvVars(log); err != nil {
-		return err
-	}
-	return backupUserDirsFile(log)
+// Prepare performs initial setup tasks like capturing and unsetting the
+// inherited XDG environment variables. It returns the inherited values.
+// user-dirs.dirs is no longer moved away: the updater rewrites it from the
+// merged result (see xdgdirs.WriteCompatUserDirs).
+func Prepare(log *logger.Logger) (map[string]string, error) {
+	return captureXDGEnvVars(log)
+}
+
+// ResetEnv performs only the environment part of Prepare, for commands that
+// read the merged directories without writing anything.
+func ResetEnv(log *logger.Logger) (map[string]string, error) {
+	return captureXDGEnvVars(log)
 }
 
-func unsetXDGEnvVars(log *logger.Logger) error {
+// captureXDGEnvVars records the inherited values before unsetting them.
+// Unsetting keeps the expansion of user.dirs deterministic; the values are
+// not lost, the merge decides per variable whether they win (see
+// xdgdirs.Policy), and by default XDG_RUNTIME_DIR does. Inherited search
+// paths also replace the spec lists as their baseline.
+func captureXDGEnvVars(log *logger.Logger) (map[string]string, error) {
 	xdgEnvVars := []string{
 		"XDG_CACHE_HOME", "XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME",
 		"XDG_RUNTIME_DIR", "XDG_DESKTOP_DIR", "XDG_DOWNLOAD_DIR", "XDG_DOCUMENTS_DIR",
 		"XDG_MUSIC_DIR", "XDG_PICTURES_DIR", "XDG_VIDEOS_DIR", "XDG_TEMPLATES_DIR",
-		"XDG_PUBLICSHARE_DIR",
+		"XDG_PUBLICSHARE_DIR", "XDG_DATA_DIRS", "XDG_CONFIG_DIRS",
 	}
+	inherited := make(map[string]string)
 	var unsetVars []string
 	for _, envVar := range xdgEnvVars {
-		if os.Getenv(envVar) != "" {
-			unsetVars = append(unsetVars, envVar)
+		if value := os.Getenv(envVar); value != "" {
+			inherited[envVar] = value
+			unsetVars = append(unsetVars, envVar+"="+value)
 			os.Unsetenv(envVar)
 		}
 	}
-	log.Debug("Unsetting XDG environment variables:\n%s", strings.Join(unsetVars, "\n"))
-	return nil
-}
-
-func backupUserDirsFile(log *logger.Logger) error {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Debug("Failed to get user home directory: %v", err)
-		return err
-	}
-	userDirsFile := filepath.Join(homeDir, ".config", "user-dirs.dirs")
-
-	if _, err := os.Stat(userDirsFile); os.IsNotExist(err) {
-		log.Debug("user-dirs.dirs didn't exist.")
-		return nil
-	} else if err != nil {
-		log.Debug("Error checking user-dirs.dirs file: %v", err)
-		return err
-	}
-
-	// If the file exists, proceed with backup
-	backupFile := filepath.Join(homeDir, ".config", "xdg", "user-dirs.dirs-backup")
-	if err := os.MkdirAll(filepath.Dir(backupFile), 0755); err != nil {
-		log.Debug("Failed to create backup directory: %v", err)
-		return err
-	}
-	if err := os.Rename(userDirsFile, backupFile); err != nil {
-		log.Debug("Failed to rename user-dirs.dirs to backup file: %v", err)
-		return err
-	}
-	log.Debug("user-dirs.dirs was backed up on %s and deleted.", backupFile)
-	return nil
+	log.Debug("Captured and unset inherited XDG environment variables:\n%s", strings.Join(unsetVars, "\n"))
+	return inherited, nil
 }
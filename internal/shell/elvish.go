@@ -0,0 +1,27 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Elvish is the canonical name of the elvish formatter.
+const Elvish = "elvish"
+
+func init() { Register(elvish{}) }
+
+type elvish struct{}
+
+func (elvish) Names() []string { return []string{Elvish} }
+
+func (elvish) Format(vars []Var) string {
+	return eachLine(vars, func(v Var) string {
+		return fmt.Sprintf("set-env %s %s", v.Key, elvishQuote(v.Value))
+	})
+}
+
+// elvishQuote produces a single-quoted elvish string: everything is literal
+// and a quote is written by doubling it.
+func elvishQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,29 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fish is the canonical name of the fish formatter.
+const Fish = "fish"
+
+func init() { Register(fish{}) }
+
+type fish struct{}
+
+func (fish) Names() []string { return []string{Fish} }
+
+func (fish) Format(vars []Var) string {
+	return eachLine(vars, func(v Var) string {
+		return fmt.Sprintf("set -gx %s %s", v.Key, fishQuote(v.Value))
+	})
+}
+
+// fishQuote wraps value in single quotes. Inside them fish only treats \\ and
+// \' as escapes, so those are the only two characters that need care.
+func fishQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
@@ -0,0 +1,45 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Nushell is the canonical name of the nushell formatter.
+const Nushell = "nu"
+
+func init() { Register(nushell{}) }
+
+// nushell cannot eval a string, so the output is a script meant to be saved
+// and sourced: a single load-env call taking a record.
+type nushell struct{}
+
+func (nushell) Names() []string { return []string{Nushell, "nushell"} }
+
+func (nushell) Format(vars []Var) string {
+	if len(vars) == 0 {
+		return "load-env {}"
+	}
+	var b strings.Builder
+	b.WriteString("load-env {\n")
+	for _, v := range vars {
+		fmt.Fprintf(&b, "    %s: %s\n", nuQuote(v.Key), nuQuote(v.Value))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+var nuEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+// nuQuote produces a double-quoted nushell string. Those support C-style
+// escapes, so backslash, the quote itself and the common control characters
+// are escaped; everything else is literal.
+func nuQuote(value string) string {
+	return `"` + nuEscaper.Replace(value) + `"`
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+func parentProcessName() string {
+	out, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(os.Getppid())).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,32 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// POSIX is the canonical name of the sh/bash/zsh family.
+const POSIX = "posix"
+
+func init() { Register(posix{}) }
+
+type posix struct{}
+
+func (posix) Names() []string {
+	return []string{POSIX, "sh", "bash", "zsh", "dash", "ash", "ksh", "mksh", "yash", "busybox"}
+}
+
+func (posix) Format(vars []Var) string {
+	return eachLine(vars, func(v Var) string {
+		return fmt.Sprintf("export %s=%s", v.Key, posixQuote(v.Value))
+	})
+}
+
+// posixQuote wraps value in single quotes, inside which a POSIX shell expands
+// nothing at all: no $, no backticks, no backslashes. A single quote cannot
+// appear inside them, so each one closes the string, adds a backslash-escaped
+// quote and reopens it. The result evaluates back to exactly value for any
+// byte string without NUL, which no environment variable can hold anyway.
+func posixQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
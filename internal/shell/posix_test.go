@@ -0,0 +1,52 @@
+package shell
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// evalPOSIX runs the export for value through sh and returns what the shell
+// ended up holding in the variable.
+func evalPOSIX(t *testing.T, value string) string {
+	t.Helper()
+	script := posix{}.Format([]Var{{Key: "XDG_TEST_DIR", Value: value}}) + "\nprintf '%s' \"$XDG_TEST_DIR\""
+	out, err := exec.Command("sh", "-c", script).Output()
+	if err != nil {
+		t.Fatalf("sh rejected %q: %v\nscript:\n%s", value, err, script)
+	}
+	return string(out)
+}
+
+func TestPOSIXQuoteRoundTrip(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"/home/x/Library/Application Support",
+		`it's`,
+		`"; rm -rf ~; "`,
+		"$(touch /tmp/pwned)",
+		"`id`",
+		`back\slash\`,
+		"new\nline",
+		"''''",
+		"${HOME:?boom}",
+	} {
+		if got := evalPOSIX(t, value); got != value {
+			t.Errorf("round trip of %q gave %q", value, got)
+		}
+	}
+}
+
+func FuzzPOSIXQuoteRoundTrip(f *testing.F) {
+	f.Add("/home/x/Application Support")
+	f.Add(`a'b"c$d\e` + "`f`")
+	f.Add("$(id)\n'\\''")
+	f.Fuzz(func(t *testing.T, value string) {
+		if strings.ContainsRune(value, 0) {
+			t.Skip("environment variables cannot hold NUL")
+		}
+		if got := evalPOSIX(t, value); got != value {
+			t.Errorf("round trip of %q gave %q", value, got)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PowerShell is the canonical name of the PowerShell formatter.
+const PowerShell = "pwsh"
+
+func init() { Register(powershell{}) }
+
+type powershell struct{}
+
+func (powershell) Names() []string { return []string{PowerShell, "powershell"} }
+
+func (powershell) Format(vars []Var) string {
+	return eachLine(vars, func(v Var) string {
+		return fmt.Sprintf("$env:%s = %s", v.Key, pwshQuote(v.Value))
+	})
+}
+
+// PowerShell treats the typographic single quotes as quote characters too,
+// so all of them are escaped by doubling, just like the ASCII one.
+var pwshEscaper = strings.NewReplacer(
+	"'", "''",
+	"‘", "‘‘",
+	"’", "’’",
+	"‚", "‚‚",
+	"‛", "‛‛",
+)
+
+// pwshQuote produces a verbatim (single-quoted) PowerShell string, in which
+// nothing is expanded and the only escape is a doubled quote.
+func pwshQuote(value string) string {
+	return "'" + pwshEscaper.Replace(value) + "'"
+}
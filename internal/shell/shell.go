@@ -0,0 +1,122 @@
+package shell
+
+// Rationale:
+// The export stream is eval'd by whatever shell invoked us, so the syntax has
+// to match that shell exactly. Each shell family is one Formatter that owns
+// its own quoting, registered from its own file; adding a shell means adding
+// one file. When no shell is requested we look at the process that spawned
+// us first (that is the shell doing the eval), then at $SHELL, and fall back
+// to POSIX.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Auto asks Parse to detect the shell instead of naming one.
+const Auto = "auto"
+
+// Var is one variable to export.
+type Var struct {
+	Key   string
+	Value string
+}
+
+// Formatter renders a set of assignments in one shell's syntax.
+type Formatter interface {
+	// Names lists the executable names this formatter answers to. The first
+	// one is canonical and is what Parse returns.
+	Names() []string
+	// Format renders vars, which arrive sorted by key, as a script for the
+	// shell. It must be deterministic and must not end in a newline.
+	Format(vars []Var) string
+}
+
+var registry = map[string]Formatter{}
+
+// Register makes f available under all of its names. It is meant to be
+// called from the init function of the file defining the formatter.
+func Register(f Formatter) {
+	for _, name := range f.Names() {
+		if _, dup := registry[name]; dup {
+			panic(fmt.Sprintf("shell: formatter %q registered twice", name))
+		}
+		registry[name] = f
+	}
+}
+
+// Get returns the formatter registered under name.
+func Get(name string) (Formatter, bool) {
+	f, ok := lookup(name)
+	return f, ok
+}
+
+// Supported lists the canonical names of every registered formatter, sorted.
+func Supported() []string {
+	var names []string
+	for name, f := range registry {
+		if f.Names()[0] == name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse turns a user-supplied shell name into a canonical formatter name.
+// "auto" and the empty string trigger detection.
+func Parse(name string) (string, error) {
+	if name == "" || name == Auto {
+		return Detect(), nil
+	}
+	if f, ok := lookup(name); ok {
+		return f.Names()[0], nil
+	}
+	return "", fmt.Errorf("unsupported shell %q (supported: %s)", name, strings.Join(Supported(), ", "))
+}
+
+// Detect guesses the shell that will eval our output: the parent process if
+// it is a known shell, then $SHELL, then POSIX.
+func Detect() string {
+	if f, ok := lookup(parentProcessName()); ok {
+		return f.Names()[0]
+	}
+	if f, ok := lookup(os.Getenv("SHELL")); ok {
+		return f.Names()[0]
+	}
+	return POSIX
+}
+
+func lookup(name string) (Formatter, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, false
+	}
+	// Login shells show up as "-bash"; $SHELL is a full path.
+	name = strings.TrimPrefix(filepath.Base(name), "-")
+	f, ok := registry[strings.ToLower(name)]
+	return f, ok
+}
+
+// Export renders vars with the formatter registered under name, falling back
+// to POSIX for unknown names.
+func Export(name string, vars []Var) string {
+	f, ok := lookup(name)
+	if !ok {
+		f = registry[POSIX]
+	}
+	return f.Format(vars)
+}
+
+// eachLine renders one line per variable, for the shells whose syntax is a
+// flat list of statements.
+func eachLine(vars []Var, line func(Var) string) string {
+	lines := make([]string, len(vars))
+	for i, v := range vars {
+		lines[i] = line(v)
+	}
+	return strings.Join(lines, "\n")
+}
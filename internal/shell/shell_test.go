@@ -0,0 +1,80 @@
+package shell
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenVars exercises the characters each formatter has to escape.
+var goldenVars = []Var{
+	{Key: "XDG_CACHE_HOME", Value: "/home/x/.cache"},
+	{Key: "XDG_CONFIG_HOME", Value: "/home/x/Library/Application Support"},
+	{Key: "XDG_DATA_HOME", Value: `/home/x/it's "quoted" \ $HOME $(id) ` + "`id`"},
+	{Key: "XDG_STATE_HOME", Value: "/home/x/tab\there/new\nline/‘smart’"},
+}
+
+// Every registered formatter must have a golden file, so a new shell cannot
+// land without its escaping being pinned down.
+func TestFormattersGolden(t *testing.T) {
+	for _, name := range Supported() {
+		t.Run(name, func(t *testing.T) {
+			f, _ := Get(name)
+			got := f.Format(goldenVars) + "\n"
+			path := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("missing golden file (run go test -update): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s:\n%s\n----\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+func TestParseKnownNames(t *testing.T) {
+	cases := map[string]string{
+		"fish":          Fish,
+		"/usr/bin/fish": Fish,
+		"-bash":         POSIX,
+		"zsh":           POSIX,
+		"sh":            POSIX,
+		"nushell":       Nushell,
+		"/usr/bin/pwsh": PowerShell,
+		"elvish":        Elvish,
+		"xonsh":         Xonsh,
+	}
+	for name, want := range cases {
+		got, err := Parse(name)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", name, err)
+		}
+		if got != want {
+			t.Errorf("Parse(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := Parse("cmd.exe"); err == nil {
+		t.Error("Parse accepted an unsupported shell")
+	}
+}
+
+// Paths need not be UTF-8. Xonsh must get surrogate escapes for the stray
+// bytes, which it turns back into the same bytes, not \x escapes, which it
+// reads as different characters.
+func TestXonshKeepsInvalidUTF8(t *testing.T) {
+	f, _ := Get(Xonsh)
+	got := f.Format([]Var{{Key: "XDG_DATA_HOME", Value: "/home/x/caf\xe9/\xff\"ok\"/é"}})
+	want := `$XDG_DATA_HOME = "/home/x/caf\udce9/\udcff\"ok\"/é"`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Xonsh is the canonical name of the xonsh formatter.
+const Xonsh = "xonsh"
+
+func init() { Register(xonsh{}) }
+
+type xonsh struct{}
+
+func (xonsh) Names() []string { return []string{Xonsh} }
+
+// Assignments to $VAR run in Python mode, so values are Python string
+// literals (see pythonQuote).
+func (xonsh) Format(vars []Var) string {
+	return eachLine(vars, func(v Var) string {
+		return fmt.Sprintf("$%s = %s", v.Key, pythonQuote(v.Value))
+	})
+}
+
+// pythonQuote quotes s like strconv.Quote, except for bytes that are not
+// valid UTF-8: Go writes those as \xNN, which Python reads as U+00NN. Python
+// decodes such path bytes to the surrogates U+DC80..U+DCFF instead
+// (surrogateescape) and encodes them back to the original bytes when it
+// passes the environment on, so that is what we write.
+func pythonQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size == 1 {
+			fmt.Fprintf(&b, `\udc%02x`, s[0])
+		} else {
+			quoted := strconv.Quote(s[:size])
+			b.WriteString(quoted[1 : len(quoted)-1])
+		}
+		s = s[size:]
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -7,22 +7,59 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/adriangalilea/xdg-dirs/internal/apps"
+	"github.com/adriangalilea/xdg-dirs/internal/audit"
+	"github.com/adriangalilea/xdg-dirs/internal/cache"
+	"github.com/adriangalilea/xdg-dirs/internal/doctor"
 	"github.com/adriangalilea/xdg-dirs/internal/logger"
+	"github.com/adriangalilea/xdg-dirs/internal/runtimedir"
+	"github.com/adriangalilea/xdg-dirs/internal/shell"
 	"github.com/adriangalilea/xdg-dirs/internal/xdgdirs"
 )
 
 type Updater struct {
-	logger  *logger.Logger
-	xdgDirs *xdgdirs.XDGDirs
+	logger    *logger.Logger
+	xdgDirs   *xdgdirs.XDGDirs
+	shell     string
+	inherited map[string]string
 }
 
 func NewUpdater(log *logger.Logger) *Updater {
 	return &Updater{
 		logger:  log,
 		xdgDirs: xdgdirs.NewXDGDirs(log),
+		shell:   shell.POSIX,
 	}
 }
 
+// SetShell selects the formatter ExportEnv uses, by any name registered in
+// the shell package.
+func (u *Updater) SetShell(name string) {
+	u.shell = name
+}
+
+// SetInherited passes the environment captured by setup.Prepare to the merge.
+func (u *Updater) SetInherited(env map[string]string) {
+	u.inherited = env
+	u.xdgDirs.SetInherited(env)
+}
+
+// XDGDirs returns the merge the updater works on, for the commands that
+// manage xdg-dirs' own files: where they live depends on the merged values.
+func (u *Updater) XDGDirs() *xdgdirs.XDGDirs {
+	return u.xdgDirs
+}
+
+// SetStrict makes any diagnostic in user.dirs fatal.
+func (u *Updater) SetStrict(strict bool) {
+	u.xdgDirs.SetStrict(strict)
+}
+
+// SetPrecedence overrides the merge's global source order.
+func (u *Updater) SetPrecedence(order []xdgdirs.Source) {
+	u.xdgDirs.SetPrecedence(order)
+}
+
 func (u *Updater) Update(userDirs map[string]string, createDirs, dryRun bool) error {
 	if dryRun {
 		u.logger.Debug("Dry run mode: No changes will be applied")
@@ -31,19 +68,21 @@ func (u *Updater) Update(userDirs map[string]string, createDirs, dryRun bool) er
 
 	if createDirs {
 		if err := u.ensureDirectories(userDirs, createDirs); err != nil {
-			u.logger.Error("Failed to ensure directories: %v", err)
+			u.logger.With("error", err).Error("Failed to ensure directories")
 			return fmt.Errorf("failed to ensure directories: %w", err)
 		}
 	}
 
 	generatedDirsPath := filepath.Join(os.Getenv("HOME"), ".config", "xdg", "generated.dirs")
 	if err := u.xdgDirs.WriteUserDirs(userDirs); err != nil {
-		u.logger.Error("Failed to write to %s: %v", generatedDirsPath, err)
+		u.logger.With("path", generatedDirsPath, "error", err).Error("Failed to write generated.dirs")
 		return fmt.Errorf("failed to write to %s: %w", generatedDirsPath, err)
 	}
 
 	u.logger.Debug("Wrote merged XDG directories to %s", generatedDirsPath)
-	return nil
+
+	// Keep user-dirs.dirs in step for GTK and other xdg-user-dirs readers.
+	return u.xdgDirs.WriteCompatUserDirs(userDirs)
 }
 
 func (u *Updater) ensureDirectories(userDirs map[string]string, createDirs bool) error {
@@ -51,14 +90,17 @@ func (u *Updater) ensureDirectories(userDirs map[string]string, createDirs bool)
 		return nil
 	}
 	for key, dir := range userDirs {
-		if dir == "" {
+		// Search paths list system directories; they are never created.
+		if dir == "" || xdgdirs.IsSearchPath(key) {
 			continue
 		}
-		dir = filepath.Clean(os.ExpandEnv(dir)) // Expand environment variables like $HOME and clean the path
+		// Values are already expanded by the merge; expanding again would
+		// turn a literal '$' into a different directory than the one exported.
+		dir = filepath.Clean(dir)
 
 		// Check if the path is valid
 		if !filepath.IsAbs(dir) {
-			u.logger.Error("Invalid directory path for %s: %s", key, dir)
+			u.logger.With("key", key, "path", dir).Error("Invalid directory path")
 			return fmt.Errorf("invalid directory path for %s: %s", key, dir)
 		}
 
@@ -67,29 +109,265 @@ func (u *Updater) ensureDirectories(userDirs map[string]string, createDirs bool)
 		if os.IsNotExist(err) {
 			err := os.MkdirAll(dir, 0700)
 			if err != nil {
-				u.logger.Error("Failed to create directory for %s: %v", key, err)
+				u.logger.With("key", key, "path", dir, "error", err).Error("Failed to create directory")
 				return fmt.Errorf("failed to create directory for %s: %w", key, err)
 			}
-			u.logger.Debug("Created directory for %s: %s", key, dir)
+			u.logger.With("key", key, "path", dir).Debug("Created directory")
 		} else if err != nil {
-			u.logger.Error("Failed to check directory for %s: %v", key, err)
+			u.logger.With("key", key, "path", dir, "error", err).Error("Failed to check directory")
 			return fmt.Errorf("failed to check directory for %s: %w", key, err)
 		} else if !info.IsDir() {
-			u.logger.Error("Path exists but is not a directory for %s: %s", key, dir)
+			u.logger.With("key", key, "path", dir).Error("Path exists but is not a directory")
 			return fmt.Errorf("path exists but is not a directory for %s: %s", key, dir)
 		}
 	}
 	return nil
 }
 
+// CheckRuntimeDir validates XDG_RUNTIME_DIR, taken from userDirs or else
+// from the inherited environment. Every way it breaks the spec is logged as
+// a warning, which goes to the log file but not to stderr unless -d: export
+// runs on every shell start, and `xdg-dirs check` shows the problems. With
+// fallback, a missing or invalid one is replaced in userDirs by the per-user
+// directory from runtimedir.EnsureFallback, so it gets exported.
+func (u *Updater) CheckRuntimeDir(userDirs map[string]string, inherited string, fallback, dryRun bool) error {
+	path, source := userDirs["XDG_RUNTIME_DIR"], "user.dirs"
+	if path == "" || path == inherited {
+		path, source = inherited, "environment"
+	}
+
+	if path != "" {
+		report := runtimedir.Check(path)
+		log := u.logger.With("key", "XDG_RUNTIME_DIR", "path", path, "source", source)
+		for _, problem := range report.Errors {
+			log.Warn("XDG_RUNTIME_DIR violates the spec: %s (see xdg-dirs check)", problem)
+		}
+		for _, warning := range report.Warnings {
+			log.Warn("XDG_RUNTIME_DIR is %s (see xdg-dirs check)", warning)
+		}
+		if report.OK() || !fallback {
+			return nil
+		}
+	} else if !fallback {
+		u.logger.Debug("XDG_RUNTIME_DIR is not set; use --runtime-fallback to create one")
+		return nil
+	}
+
+	if dryRun {
+		u.logger.Debug("Dry run mode: would use fallback XDG_RUNTIME_DIR %s", runtimedir.FallbackPath())
+		return nil
+	}
+	dir, err := runtimedir.EnsureFallback()
+	if err != nil {
+		u.logger.With("error", err).Error("Failed to set up fallback XDG_RUNTIME_DIR")
+		return fmt.Errorf("failed to set up fallback XDG_RUNTIME_DIR: %w", err)
+	}
+	userDirs["XDG_RUNTIME_DIR"] = dir
+	u.logger.With("key", "XDG_RUNTIME_DIR", "path", dir).Debug("Using fallback XDG_RUNTIME_DIR")
+	return nil
+}
+
+// AppEnv resolves the variables of the apps user.dirs opts into with @apps
+// against the merged directories.
+func (u *Updater) AppEnv(userDirs map[string]string) ([]apps.Export, error) {
+	names, err := u.xdgDirs.SelectedApps()
+	if err != nil {
+		return nil, err
+	}
+	exports, err := apps.Resolve(names, userDirs)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if rule, _ := apps.Lookup(name); rule.Note != "" {
+			u.logger.With("app", name).Debug("%s", rule.Note)
+		}
+	}
+	return exports, nil
+}
+
+// EnsureAppDirs creates the directories the app variables point to, or
+// hold the files they name. Private ones, like GNUPGHOME, are made 0700.
+func (u *Updater) EnsureAppDirs(exports []apps.Export) error {
+	for _, e := range exports {
+		dir := e.Dir()
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				u.logger.With("key", e.Name, "path", dir).Error("Path exists but is not a directory")
+				return fmt.Errorf("path exists but is not a directory for %s: %s", e.Name, dir)
+			}
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to check directory for %s: %w", e.Name, err)
+		}
+		mode := os.FileMode(0755)
+		if e.Private {
+			mode = 0700
+		}
+		if err := os.MkdirAll(dir, mode); err != nil {
+			u.logger.With("key", e.Name, "path", dir, "error", err).Error("Failed to create directory")
+			return fmt.Errorf("failed to create directory for %s: %w", e.Name, err)
+		}
+		u.logger.With("key", e.Name, "path", dir).Debug("Created directory")
+	}
+	return nil
+}
+
+// ImportLegacy imports the directories of an existing user-dirs.dirs into
+// user.dirs, unless that already happened once. It returns what was imported,
+// or would be with dryRun, or nil when the import already ran.
+func (u *Updater) ImportLegacy(dryRun bool) (*xdgdirs.ImportPlan, error) {
+	done, err := u.xdgDirs.Imported()
+	if err != nil || done {
+		return nil, err
+	}
+	plan, err := u.xdgDirs.PlanImport()
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		if plan.Source != "" {
+			u.logger.Debug("Dry run mode: would import %d directories from %s", len(plan.Entries), plan.Source)
+		}
+		return plan, nil
+	}
+	if err := u.xdgDirs.ApplyImport(plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// fingerprint returns the cache directory and the fingerprint of the current
+// inputs: the files export reads and writes, the inherited environment, the
+// shell and options.
+func (u *Updater) fingerprint(options []string) (string, string, error) {
+	var files []string
+	for _, path := range []func() (string, error){
+		u.xdgDirs.UserDirsPath, u.xdgDirs.ExtrasPath, u.xdgDirs.GeneratedDirsPath, u.xdgDirs.LegacyUserDirsPath,
+	} {
+		file, err := path()
+		if err != nil {
+			return "", "", err
+		}
+		files = append(files, file)
+	}
+	stateDir, err := u.xdgDirs.StateDir()
+	if err != nil {
+		return "", "", err
+	}
+	env := make(map[string]string, len(u.inherited)+1)
+	for key, value := range u.inherited {
+		env[key] = value
+	}
+	env["HOME"] = os.Getenv("HOME")
+	options = append([]string{"shell=" + u.shell}, options...)
+	return filepath.Join(stateDir, "cache"), cache.Fingerprint(files, env, options...), nil
+}
+
+// CachedExports returns the exports of an earlier run with the same inputs
+// and options, if there is one. It writes nothing.
+func (u *Updater) CachedExports(options ...string) (string, bool) {
+	dir, fingerprint, err := u.fingerprint(options)
+	if err != nil {
+		return "", false
+	}
+	entry, ok := cache.Load(dir, fingerprint)
+	if ok {
+		u.logger.Debug("Using the exports cached as %s", fingerprint)
+	}
+	return entry.Exports, ok
+}
+
+// StoreExports caches exports for the next run with the same inputs and
+// options. Call it after everything is written, so the fingerprint covers the
+// files as this run left them. dirs must still exist for the entry to be used.
+func (u *Updater) StoreExports(exports string, dirs []string, options ...string) error {
+	dir, fingerprint, err := u.fingerprint(options)
+	if err != nil {
+		return err
+	}
+	if err := cache.Store(dir, fingerprint, cache.Entry{Exports: exports, Dirs: dirs}); err != nil {
+		return fmt.Errorf("failed to cache the exports: %w", err)
+	}
+	u.logger.Debug("Cached the exports as %s", fingerprint)
+	return nil
+}
+
 func (u *Updater) GetUserDirs() (map[string]string, error) {
 	return u.xdgDirs.ReadUserDirs()
 }
 
-// ExportEnv emits one export line per XDG_* variable, sorted by name.
+// Explain returns the origin of every merged variable, sorted by name.
+func (u *Updater) Explain() ([]xdgdirs.Origin, error) {
+	origins, err := u.xdgDirs.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return sortedOrigins(origins), nil
+}
+
+func sortedOrigins(origins map[string]xdgdirs.Origin) []xdgdirs.Origin {
+	sorted := make([]xdgdirs.Origin, 0, len(origins))
+	for _, origin := range origins {
+		sorted = append(sorted, origin)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
+
+// Check runs the doctor checks on the merge, user.dirs and generated.dirs.
+// Problems in user.dirs become findings, all of them errors with strict.
+func (u *Updater) Check(strict bool) ([]doctor.Finding, error) {
+	origins, diagnostics, err := u.xdgDirs.ResolveAll()
+	if err != nil {
+		return nil, err
+	}
+	generatedPath, err := u.xdgDirs.GeneratedDirsPath()
+	if err != nil {
+		return nil, err
+	}
+	generated, err := u.xdgDirs.ReadGeneratedDirs()
+	if err != nil {
+		return nil, err
+	}
+	return doctor.Run(doctor.Input{
+		Dirs:          sortedOrigins(origins),
+		Diagnostics:   diagnostics,
+		Strict:        strict,
+		GeneratedPath: generatedPath,
+		Generated:     generated,
+	}), nil
+}
+
+// Audit scans home for the files of the apps database, leaving out the
+// resolved XDG directories, and marks the apps already in @apps.
+func (u *Updater) Audit(home string, depth int) ([]audit.Finding, error) {
+	userDirs, err := u.xdgDirs.ReadUserDirs()
+	if err != nil {
+		return nil, err
+	}
+	selected, err := u.xdgDirs.SelectedApps()
+	if err != nil {
+		return nil, err
+	}
+	var skip []string
+	for key, dir := range userDirs {
+		if !xdgdirs.IsSearchPath(key) {
+			skip = append(skip, dir)
+		}
+	}
+	return audit.Scan(home, audit.Options{Depth: depth, Skip: skip, Selected: selected})
+}
+
+// ExportEnv emits one export line per XDG_* variable, sorted by name, then
+// one per app variable in the order given, in the syntax of the shell
+// selected with SetShell. The app variables come last so they can be read
+// as derived from the XDG ones.
 // Sorted output is a contract: identical state must produce byte-identical
 // output, so callers can diff runs exactly.
-func (u *Updater) ExportEnv(userDirs map[string]string) string {
+func (u *Updater) ExportEnv(userDirs map[string]string, appVars ...apps.Export) string {
 	merged := make(map[string]string, len(u.xdgDirs.Dirs)+len(userDirs))
 	for key, value := range u.xdgDirs.Dirs {
 		merged[key] = value
@@ -106,9 +384,12 @@ func (u *Updater) ExportEnv(userDirs map[string]string) string {
 	}
 	sort.Strings(keys)
 
-	exports := make([]string, len(keys))
-	for i, key := range keys {
-		exports[i] = fmt.Sprintf("export %s=\"%s\"", key, merged[key])
+	vars := make([]shell.Var, 0, len(keys)+len(appVars))
+	for _, key := range keys {
+		vars = append(vars, shell.Var{Key: key, Value: merged[key]})
+	}
+	for _, e := range appVars {
+		vars = append(vars, shell.Var{Key: e.Name, Value: e.Value})
 	}
-	return strings.Join(exports, "\n")
+	return shell.Export(u.shell, vars)
 }
@@ -1,12 +1,15 @@
 package updater
 
 import (
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
 
+	"github.com/adriangalilea/xdg-dirs/internal/apps"
 	"github.com/adriangalilea/xdg-dirs/internal/logger"
+	"github.com/adriangalilea/xdg-dirs/internal/shell"
 )
 
 // The determinism contract: identical state produces byte-identical output,
@@ -47,7 +50,214 @@ func TestExportEnvUserOverridesDefaults(t *testing.T) {
 
 	userDirs := map[string]string{"XDG_DESKTOP_DIR": "/custom/desk"}
 	out := u.ExportEnv(userDirs)
-	if !strings.Contains(out, "export XDG_DESKTOP_DIR=\"/custom/desk\"") {
+	if !strings.Contains(out, "export XDG_DESKTOP_DIR='/custom/desk'") {
 		t.Fatalf("user override lost in export:\n%s", out)
 	}
 }
+
+// Fish output keeps the same contract: sorted, byte-identical, and every line
+// is a `set -gx` assignment.
+func TestExportEnvFishDeterministicAndSorted(t *testing.T) {
+	log := logger.NewLogger(false, filepath.Join(t.TempDir(), "test.log"))
+	u := NewUpdater(log)
+	u.SetShell(shell.Fish)
+
+	userDirs := map[string]string{
+		"XDG_DESKTOP_DIR":  "/home/x/Desktop",
+		"XDG_DOWNLOAD_DIR": "/home/x/it's here",
+		"NOT_XDG":          "must-not-appear",
+	}
+
+	first := u.ExportEnv(userDirs)
+	for i := 0; i < 50; i++ {
+		if got := u.ExportEnv(userDirs); got != first {
+			t.Fatalf("run %d differs from first run:\n%s\n----\n%s", i, got, first)
+		}
+	}
+
+	lines := strings.Split(first, "\n")
+	if !sort.StringsAreSorted(lines) {
+		t.Fatalf("export lines are not sorted:\n%s", first)
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "set -gx XDG_") {
+			t.Fatalf("unexpected line (non-XDG leaked or bad format): %q", line)
+		}
+	}
+	if !strings.Contains(first, `set -gx XDG_DOWNLOAD_DIR '/home/x/it\'s here'`) {
+		t.Fatalf("fish quoting wrong:\n%s", first)
+	}
+}
+
+// App variables follow the sorted XDG exports, in the order given.
+func TestExportEnvAppsAfterXDG(t *testing.T) {
+	log := logger.NewLogger(false, filepath.Join(t.TempDir(), "test.log"))
+	u := NewUpdater(log)
+
+	userDirs := map[string]string{"XDG_DATA_HOME": "/home/x/.local/share"}
+	appVars, err := apps.Resolve([]string{"cargo", "npm"}, map[string]string{
+		"XDG_CACHE_HOME":  "/home/x/.cache",
+		"XDG_CONFIG_HOME": "/home/x/.config",
+		"XDG_DATA_HOME":   "/home/x/.local/share",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(u.ExportEnv(userDirs, appVars...), "\n")
+	tail := strings.Join(lines[len(lines)-3:], "\n")
+	want := `export CARGO_HOME='/home/x/.local/share/cargo'
+export NPM_CONFIG_USERCONFIG='/home/x/.config/npm/npmrc'
+export npm_config_cache='/home/x/.cache/npm'`
+	if tail != want {
+		t.Fatalf("app exports =\n%s\nwant\n%s", tail, want)
+	}
+	for _, line := range lines[:len(lines)-3] {
+		if !strings.HasPrefix(line, "export XDG_") {
+			t.Fatalf("XDG exports must come first, got %q", line)
+		}
+	}
+}
+
+// -c creates the directory that is exported: a literal '$' in a resolved
+// value is not expanded a second time.
+func TestEnsureDirectoriesUsesResolvedValue(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("H", "/expanded")
+	log := logger.NewLogger(false, filepath.Join(home, "test.log"))
+	u := NewUpdater(log)
+
+	literal := filepath.Join(home, "$H", "lit")
+	if err := u.ensureDirectories(map[string]string{"XDG_NOTES_DIR": literal}, true); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(literal); err != nil || !fi.IsDir() {
+		t.Errorf("%s was not created: %v", literal, err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "expanded")); err == nil {
+		t.Error("the value was expanded again")
+	}
+}
+
+// newTestHome points every XDG path of a fresh Updater into a temporary home.
+func newTestHome(tb testing.TB) *Updater {
+	home := tb.TempDir()
+	tb.Setenv("HOME", home)
+	tb.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	tb.Setenv("XDG_STATE_HOME", filepath.Join(home, ".local", "state"))
+	u := NewUpdater(logger.NewLogger(false, filepath.Join(home, "test.log")))
+	u.SetInherited(map[string]string{})
+	return u
+}
+
+// export is the slow path of the export command, with no cache.
+func export(tb testing.TB, u *Updater) string {
+	userDirs, err := u.GetUserDirs()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := u.Update(userDirs, false, false); err != nil {
+		tb.Fatal(err)
+	}
+	return u.ExportEnv(userDirs)
+}
+
+// A cached run prints what the full run printed, and any change to the
+// inputs or options misses the cache.
+func TestCachedExports(t *testing.T) {
+	u := newTestHome(t)
+	if _, ok := u.CachedExports("strict=false"); ok {
+		t.Fatal("hit before anything was stored")
+	}
+	want := export(t, u)
+	if err := u.StoreExports(want, nil, "strict=false"); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := u.CachedExports("strict=false")
+	if !ok || got != want {
+		t.Fatalf("cached exports = %q, %v; want %q", got, ok, want)
+	}
+	if _, ok := u.CachedExports("strict=true"); ok {
+		t.Error("hit with different options")
+	}
+	u.SetShell(shell.Fish)
+	if _, ok := u.CachedExports("strict=false"); ok {
+		t.Error("hit with a different shell")
+	}
+	u.SetShell(shell.POSIX)
+
+	if err := u.xdgDirs.SetUserDir("XDG_MUSIC_DIR", "/data/music"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := u.CachedExports("strict=false"); ok {
+		t.Error("hit after user.dirs changed")
+	}
+}
+
+// Compare with BenchmarkExportFull: the cost of a shell start with nothing
+// changed.
+func BenchmarkExportCached(b *testing.B) {
+	u := newTestHome(b)
+	if err := u.StoreExports(export(b, u), nil); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := u.CachedExports(); !ok {
+			b.Fatal("cache miss")
+		}
+	}
+}
+
+func BenchmarkExportFull(b *testing.B) {
+	u := newTestHome(b)
+	export(b, u)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		export(b, u)
+	}
+}
+
+// A runtime dir that breaks the spec is a warning in the log, but the export
+// path prints nothing about it.
+func TestCheckRuntimeDirQuiet(t *testing.T) {
+	home := t.TempDir()
+	logFile := filepath.Join(home, "logs", "test.log")
+	log := logger.NewLogger(false, logFile)
+	var stderr strings.Builder
+	log.SetDiagnostics(&stderr)
+	u := NewUpdater(log)
+
+	runtimeDir := filepath.Join(home, "run")
+	os.Mkdir(runtimeDir, 0755) // the spec wants 0700
+	if err := u.CheckRuntimeDir(map[string]string{}, runtimeDir, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(logFile); !strings.Contains(string(content), "WARN: ") ||
+		!strings.Contains(string(content), "XDG_RUNTIME_DIR violates the spec: mode is 0755") {
+		t.Errorf("invalid XDG_RUNTIME_DIR not logged as a warning:\n%s", content)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("export printed %q", stderr.String())
+	}
+}
+
+// A dry run with no user-dirs.dirs anywhere has nothing to announce.
+func TestDryRunImportWithoutSource(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_STATE_HOME", "")
+	log := logger.NewLogger(true, filepath.Join(home, "test.log"))
+	var stderr strings.Builder
+	log.SetDiagnostics(&stderr)
+	u := NewUpdater(log)
+
+	plan, err := u.ImportLegacy(true)
+	if err != nil || plan == nil || plan.Source != "" {
+		t.Fatalf("ImportLegacy = %+v, %v", plan, err)
+	}
+	if strings.Contains(stderr.String(), "would import") {
+		t.Errorf("dry run announced an import without a source:\n%s", stderr.String())
+	}
+}
@@ -0,0 +1,66 @@
+package xdgdirs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/adriangalilea/xdg-dirs/internal/apps"
+)
+
+// SelectedApps returns the apps user.dirs opts into with @apps, known to the
+// apps database, sorted and without duplicates.
+func (x *XDGDirs) SelectedApps() ([]string, error) {
+	file, err := x.ParseUserDirs()
+	if err != nil {
+		return nil, err
+	}
+	names, _ := selectApps(file)
+	return names, nil
+}
+
+// selectApps returns the known names in @apps, sorted and without
+// duplicates, and a warning for every unknown one.
+func selectApps(file *UserDirsFile) ([]string, []Diagnostic) {
+	var diagnostics []Diagnostic
+	known := apps.Names()
+	seen := make(map[string]bool)
+	var names []string
+	for _, entry := range file.Apps {
+		rule, ok := apps.Lookup(entry.Key)
+		var message string
+		switch {
+		case !ok:
+			message = fmt.Sprintf("%s in @apps is not a known app%s", entry.Key, suggestApp(entry.Key, known))
+		case rule.Fix() != apps.FixEnv:
+			message = fmt.Sprintf("%s in @apps has no variable to relocate it (%s)", entry.Key, rule.Fix())
+		}
+		if message != "" {
+			diagnostics = append(diagnostics, Diagnostic{
+				File: file.Path, Line: entry.Line, Column: entry.KeyColumn, Severity: SeverityWarning, Message: message,
+			})
+			continue
+		}
+		if !seen[entry.Key] {
+			seen[entry.Key] = true
+			names = append(names, entry.Key)
+		}
+	}
+	sort.Strings(names)
+	return names, diagnostics
+}
+
+// suggestApp is suggestKey for app names, which are lowercase.
+func suggestApp(name string, known []string) string {
+	lower := strings.ToLower(name)
+	best, bestDistance := "", 3
+	for _, app := range known {
+		if d := editDistance(lower, app); d < bestDistance {
+			best, bestDistance = app, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
@@ -0,0 +1,270 @@
+package xdgdirs
+
+// Rationale:
+// A single user-dirs.dirs-backup was overwritten by the next backup, so the
+// file worth keeping - the one xdg-user-dirs wrote before xdg-dirs took over -
+// could be lost to a later, less interesting one. Backups are now numbered
+// files under $XDG_STATE_HOME/xdg-dirs/backups, one per replaced version of
+// user-dirs.dirs or generated.dirs:
+//
+//	0003-generated.dirs-20261016T101500Z
+//
+// IDs only grow, so `restore 3` means the same file until it is pruned. The
+// BackupRetention most recent backups of each kind are kept. generated.dirs
+// is only backed up when its content changes, not on every shell start.
+//
+// Both files are written from user.dirs by every export. Restoring
+// user-dirs.dirs therefore also sets its directories in user.dirs, or the
+// next shell start would undo it; a restored generated.dirs is only a
+// snapshot until then.
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adriangalilea/xdg-dirs/internal/fslock"
+)
+
+// Kinds of backup, named after the file they hold.
+const (
+	BackupUserDirs  = "user-dirs.dirs"
+	BackupGenerated = "generated.dirs"
+)
+
+// BackupRetention is how many backups of each kind are kept.
+const BackupRetention = 10
+
+// Backup is one saved version of a file.
+type Backup struct {
+	ID   int
+	Kind string
+	Time time.Time
+	Path string
+}
+
+// BackupDir is where backups are kept.
+func (x *XDGDirs) BackupDir() (string, error) {
+	dir, err := x.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "backups"), nil
+}
+
+// Backups returns every backup, oldest first.
+func (x *XDGDirs) Backups() ([]Backup, error) {
+	dir, err := x.BackupDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var backups []Backup
+	for _, entry := range entries {
+		if b, ok := parseBackupName(entry.Name()); ok {
+			b.Path = filepath.Join(dir, entry.Name())
+			backups = append(backups, b)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ID < backups[j].ID })
+	return backups, nil
+}
+
+// parseBackupName reads "0003-generated.dirs-20261016T101500Z".
+func parseBackupName(name string) (Backup, bool) {
+	idPart, rest, ok := strings.Cut(name, "-")
+	if !ok {
+		return Backup{}, false
+	}
+	i := strings.LastIndex(rest, "-")
+	if i < 0 {
+		return Backup{}, false
+	}
+	id, err := strconv.Atoi(idPart)
+	if err != nil {
+		return Backup{}, false
+	}
+	t, err := time.Parse("20060102T150405Z", rest[i+1:])
+	if err != nil {
+		return Backup{}, false
+	}
+	kind := rest[:i]
+	if kind != BackupUserDirs && kind != BackupGenerated {
+		return Backup{}, false
+	}
+	return Backup{ID: id, Kind: kind, Time: t}, true
+}
+
+// SaveBackup stores content as the newest backup of kind and prunes the
+// oldest ones beyond BackupRetention. Content identical to the newest backup
+// of kind is not saved twice.
+func (x *XDGDirs) SaveBackup(kind string, content []byte) (Backup, error) {
+	dir, err := x.BackupDir()
+	if err != nil {
+		return Backup{}, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Backup{}, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	// Numbering and pruning read the directory first; take turns with the
+	// other processes doing the same.
+	lock, err := fslock.Acquire(filepath.Join(dir, ".lock"))
+	if err != nil {
+		return Backup{}, err
+	}
+	defer lock.Release()
+
+	backups, err := x.Backups()
+	if err != nil {
+		return Backup{}, err
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].Kind != kind {
+			continue
+		}
+		if latest, err := os.ReadFile(backups[i].Path); err == nil && bytes.Equal(latest, content) {
+			return backups[i], nil // already saved, e.g. restored and replaced again
+		}
+		break
+	}
+
+	b := Backup{ID: 1, Kind: kind, Time: time.Now().UTC().Truncate(time.Second)}
+	if len(backups) > 0 {
+		b.ID = backups[len(backups)-1].ID + 1
+	}
+	b.Path = filepath.Join(dir, fmt.Sprintf("%04d-%s-%s", b.ID, kind, b.Time.Format("20060102T150405Z")))
+	if err := writeFileAtomic(b.Path, content, 0600); err != nil {
+		return Backup{}, fmt.Errorf("failed to write backup: %w", err)
+	}
+	x.logger.With("path", b.Path).Debug("Backed up %s as backup %d", kind, b.ID)
+
+	var same []Backup
+	for _, old := range append(backups, b) {
+		if old.Kind == kind {
+			same = append(same, old)
+		}
+	}
+	for len(same) > BackupRetention {
+		if err := os.Remove(same[0].Path); err != nil {
+			x.logger.With("path", same[0].Path, "error", err).Warn("Failed to prune old backup")
+		}
+		same = same[1:]
+	}
+	return b, nil
+}
+
+// LatestBackup returns the newest backup of kind, if any.
+func (x *XDGDirs) LatestBackup(kind string) (Backup, bool, error) {
+	backups, err := x.Backups()
+	if err != nil {
+		return Backup{}, false, err
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].Kind == kind {
+			return backups[i], true, nil
+		}
+	}
+	return Backup{}, false, nil
+}
+
+// backupTarget is the file a backup of kind restores to.
+func (x *XDGDirs) backupTarget(kind string) (string, error) {
+	if kind == BackupUserDirs {
+		return x.LegacyUserDirsPath()
+	}
+	return x.GeneratedDirsPath()
+}
+
+// Restored is what Restore did.
+type Restored struct {
+	Backup
+	Target string        // the file put back in place
+	Set    []ImportEntry // directories set in user.dirs to match it
+}
+
+// Restore puts backup id back in place. The file it replaces is backed up
+// first, so a restore can itself be undone.
+func (x *XDGDirs) Restore(id int) (*Restored, error) {
+	backups, err := x.Backups()
+	if err != nil {
+		return nil, err
+	}
+	var b Backup
+	for _, candidate := range backups {
+		if candidate.ID == id {
+			b = candidate
+		}
+	}
+	if b.ID == 0 {
+		return nil, fmt.Errorf("no backup %d", id)
+	}
+	content, err := os.ReadFile(b.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup %d: %w", id, err)
+	}
+	target, err := x.backupTarget(b.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := os.ReadFile(target)
+	switch {
+	case err == nil && !bytes.Equal(current, content):
+		if _, err := x.SaveBackup(b.Kind, current); err != nil {
+			return nil, err
+		}
+	case err != nil && !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read %s: %w", target, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+	}
+	if err := writeFileAtomic(target, content, 0644); err != nil {
+		return nil, fmt.Errorf("failed to restore %s: %w", target, err)
+	}
+	x.logger.With("path", target).Info("Restored backup %d", id)
+
+	restored := &Restored{Backup: b, Target: target}
+	if b.Kind == BackupUserDirs {
+		if restored.Set, err = x.adoptUserDirs(target, content); err != nil {
+			return nil, err
+		}
+	}
+	return restored, nil
+}
+
+// adoptUserDirs sets in user.dirs every directory of a restored
+// user-dirs.dirs that the merge resolves differently, so that the next
+// export writes the same values back.
+func (x *XDGDirs) adoptUserDirs(path string, content []byte) ([]ImportEntry, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	merged, err := x.ReadUserDirs()
+	if err != nil {
+		return nil, err
+	}
+	var set []ImportEntry
+	for _, entry := range x.legacyEntries(path, content, home) {
+		if filepath.Clean(merged[entry.Key]) == entry.Value {
+			continue
+		}
+		if err := x.SetUserDir(entry.Key, entry.Value); err != nil {
+			return nil, fmt.Errorf("failed to set %s in user.dirs: %w", entry.Key, err)
+		}
+		set = append(set, entry)
+	}
+	return set, nil
+}
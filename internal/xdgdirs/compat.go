@@ -0,0 +1,128 @@
+package xdgdirs
+
+// Rationale:
+// GTK file choosers, Nautilus and everything else built on xdg-user-dirs read
+// the user directories from user-dirs.dirs, not from the environment. Moving
+// that file away took their sidebar folders with it, so it is now an output:
+// rewritten from the merged result on every run, in the only format
+// xdg-user-dirs promises to read ("$HOME/yyy" or "/yyy"). A user-dirs.dirs
+// this tool did not write - from xdg-user-dirs-update or by hand - is backed
+// up once before it is replaced; one it wrote, recognized by its header, is
+// simply overwritten. It goes in the XDG_CONFIG_HOME being exported, which
+// user.dirs may move away from ~/.config.
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// compatHeader starts every user-dirs.dirs written by xdg-dirs.
+const compatHeader = "# This file is written by xdg-dirs"
+
+// userDirKey matches the keys user-dirs.dirs may hold.
+var userDirKey = regexp.MustCompile(`^XDG_[A-Z0-9_]+_DIR$`)
+
+// LegacyUserDirsPath is the location of user-dirs.dirs: in the merged
+// XDG_CONFIG_HOME, where GTK and glib look for it once the exports apply.
+func (x *XDGDirs) LegacyUserDirsPath() (string, error) {
+	configHome, err := x.mergedDir("XDG_CONFIG_HOME")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configHome, "user-dirs.dirs"), nil
+}
+
+// FormatCompatUserDirs renders userDirs in the format of xdg-user-dirs:
+// only the XDG_*_DIR variables, sorted, relative to home where possible.
+// Values that are neither absolute nor under home cannot be written and are
+// left out.
+func FormatCompatUserDirs(userDirs map[string]string, home string) []byte {
+	var b bytes.Buffer
+	b.WriteString(compatHeader + " from user.dirs and the defaults. Do not\n")
+	b.WriteString("# edit: it is rewritten on every run. Change the directories in\n")
+	b.WriteString("# xdg/user.dirs instead, or with xdg-dirs set.\n")
+	b.WriteString("# Format is XDG_xxx_DIR=\"$HOME/yyy\", where yyy is a shell-escaped\n")
+	b.WriteString("# homedir-relative path, or XDG_xxx_DIR=\"/yyy\", where /yyy is an\n")
+	b.WriteString("# absolute path, as xdg-user-dirs expects.\n")
+
+	keys := make([]string, 0, len(userDirs))
+	for key := range userDirs {
+		if userDirKey.MatchString(key) && key != "XDG_RUNTIME_DIR" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := filepath.Clean(userDirs[key])
+		if !filepath.IsAbs(value) {
+			continue
+		}
+		switch rel, err := filepath.Rel(home, value); {
+		case err != nil || rel == ".." || strings.HasPrefix(rel, "../"):
+			fmt.Fprintf(&b, "%s=\"%s\"\n", key, shellEscape(value))
+		case rel == ".":
+			fmt.Fprintf(&b, "%s=\"$HOME/\"\n", key) // a disabled directory
+		default:
+			fmt.Fprintf(&b, "%s=\"$HOME/%s\"\n", key, shellEscape(filepath.ToSlash(rel)))
+		}
+	}
+	return b.Bytes()
+}
+
+// shellEscape escapes what is special inside double quotes.
+func shellEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune("\"\\`$", c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// WriteCompatUserDirs writes user-dirs.dirs from the merged userDirs. A file
+// not written by xdg-dirs is backed up first; an identical one is left
+// untouched.
+func (x *XDGDirs) WriteCompatUserDirs(userDirs map[string]string) error {
+	path, err := x.LegacyUserDirsPath()
+	if err != nil {
+		return err
+	}
+	if configHome := userDirs["XDG_CONFIG_HOME"]; filepath.IsAbs(configHome) {
+		path = filepath.Join(configHome, "user-dirs.dirs")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	content := FormatCompatUserDirs(userDirs, home)
+
+	current, err := os.ReadFile(path)
+	switch {
+	case err == nil && bytes.Equal(current, content):
+		x.logger.Debug("%s is up to date", path)
+		return nil
+	case err == nil && !bytes.HasPrefix(current, []byte(compatHeader)):
+		if _, err := x.SaveBackup(BackupUserDirs, current); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+	case err != nil && !os.IsNotExist(err):
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := writeFileAtomic(path, content, 0644); err != nil {
+		x.logger.With("path", path, "error", err).Error("Failed to write user-dirs.dirs")
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	x.logger.Debug("Wrote %s for xdg-user-dirs readers", path)
+	return nil
+}
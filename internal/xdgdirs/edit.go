@@ -0,0 +1,202 @@
+package xdgdirs
+
+// Rationale:
+// user.dirs belongs to the user, so `set` and `unset` behave like a careful
+// hand edit: only the lines defining the key change, while comments, ordering
+// and lines we don't understand stay byte-for-byte. The new content is written
+// to a temporary file next to user.dirs and renamed over it, so a crash leaves
+// either the old file or the new one, never a truncated mix.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var validKey = regexp.MustCompile(`^XDG_[A-Z0-9_]+$`)
+
+// SetUserDir defines key in user.dirs, rewriting the lines that already define
+// it or appending one if none does. Absolute paths under $HOME are stored as
+// "$HOME/..." like xdg-user-dirs-update does; values starting with $ or ~ are
+// stored verbatim.
+func (x *XDGDirs) SetUserDir(key, value string) error {
+	if !validKey.MatchString(key) {
+		return fmt.Errorf("invalid variable name %q", key)
+	}
+	value, err := storedValue(value)
+	if err != nil {
+		return err
+	}
+
+	path, err := x.UserDirsPath()
+	if err != nil {
+		return err
+	}
+	lines, err := readLines(path)
+	if err != nil {
+		x.logger.With("path", path, "error", err).Error("Failed to read user.dirs")
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	found := false
+	for i, line := range lines {
+		if lineKey(line) == key {
+			lines[i] = fmt.Sprintf("%s=\"%s\"%s", key, value, inlineComment(line))
+			found = true
+		}
+	}
+	if !found {
+		lines = append(lines, fmt.Sprintf("%s=\"%s\"", key, value))
+	}
+
+	if err := writeLines(path, lines); err != nil {
+		x.logger.With("path", path, "error", err).Error("Failed to write user.dirs")
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	x.logger.With("key", key, "value", value, "path", path).Info("Set directory in user.dirs")
+	return nil
+}
+
+// UnsetUserDir removes every line defining key from user.dirs so the default
+// applies again. It reports whether anything was removed.
+func (x *XDGDirs) UnsetUserDir(key string) (bool, error) {
+	path, err := x.UserDirsPath()
+	if err != nil {
+		return false, err
+	}
+	lines, err := readLines(path)
+	if err != nil {
+		x.logger.With("path", path, "error", err).Error("Failed to read user.dirs")
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	kept := lines[:0]
+	for _, line := range lines {
+		if lineKey(line) != key {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == len(lines) {
+		return false, nil
+	}
+
+	if err := writeLines(path, kept); err != nil {
+		x.logger.With("path", path, "error", err).Error("Failed to write user.dirs")
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	x.logger.With("key", key, "path", path).Info("Unset directory in user.dirs")
+	return true, nil
+}
+
+// storedValue validates value and converts it to the form written to
+// user.dirs. Quotes, # and newlines are rejected because ReadUserDirs would
+// read them back as delimiters or comments.
+func storedValue(value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if strings.ContainsAny(value, "\"#\n\r") {
+		return "", fmt.Errorf("path %q contains a quote, # or newline, which user.dirs cannot hold", value)
+	}
+	if strings.HasPrefix(value, "$") || strings.HasPrefix(value, "~") {
+		return value, nil
+	}
+
+	abs, err := filepath.Abs(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to make %q absolute: %w", value, err)
+	}
+	// A $ in a path is literal; escape it so the expansion leaves it alone.
+	// Backslashes are doubled, since the parser reads \\ as one.
+	escape := strings.NewReplacer(`\`, `\\`, "$", `\$`).Replace
+	if home, err := os.UserHomeDir(); err == nil {
+		if rel, err := filepath.Rel(home, abs); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return "$HOME/" + escape(filepath.ToSlash(rel)), nil
+		}
+	}
+	return escape(abs), nil
+}
+
+// lineKey returns the variable a user.dirs line assigns, as the parser sees
+// it, or "" for comments, directives and anything else. Lines with a syntax
+// error after the = still count, so set replaces them.
+func lineKey(line string) string {
+	s, ok := scanLine(line)
+	if !ok || !s.hasEquals || strings.HasPrefix(s.name, "@") {
+		return ""
+	}
+	return s.name
+}
+
+// inlineComment returns the trailing "  # ..." part of a line, if any, so a
+// rewritten line keeps its comment.
+func inlineComment(line string) string {
+	value := strings.SplitN(line, "=", 2)[1]
+	idx := strings.Index(value, "#")
+	if idx == -1 {
+		return ""
+	}
+	trimmed := strings.TrimRight(value[:idx], " \t")
+	return value[len(trimmed):]
+}
+
+// readLines returns the lines of path without the final newline. A missing
+// file is an empty one.
+func readLines(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(string(content), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+func writeLines(path string, lines []string) error {
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		content += "\n"
+	}
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(content), perm)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory,
+// syncs it and renames it over path.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once the rename succeeded
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
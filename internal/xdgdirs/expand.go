@@ -0,0 +1,329 @@
+package xdgdirs
+
+// Rationale:
+// Values used to go through os.ExpandEnv, after setup had unset the XDG
+// variables, so "${XDG_DATA_HOME}/foo" silently became "/foo". Now a
+// reference to a variable this tool manages means the value the merge gives
+// it, whichever layer that comes from, and entries are expanded on demand so
+// the order of the lines does not matter. Anything else ($HOME, $USER) still
+// comes from the environment.
+//
+// Supported, as in sh:
+//
+//	~/Downloads  ~  $NAME  ${NAME}  ${NAME:-fallback}  ${NAME:?message}  \$
+//
+// A variable that refers to itself means its value before this line (the
+// previous line setting it, or the default), which is how search paths are
+// extended. Any other loop is a cycle and is reported with the whole chain.
+// An entry that cannot be expanded is left out, so the next layer wins.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandError is a failure to expand a value, at a byte offset in it.
+type expandError struct {
+	offset int
+	err    error
+}
+
+func (e *expandError) Error() string { return e.err.Error() }
+func (e *expandError) Unwrap() error { return e.err }
+
+// cycleError is a chain of references that leads back to its start.
+type cycleError struct{ chain []string }
+
+func (e *cycleError) Error() string {
+	return "reference cycle: " + strings.Join(e.chain, " -> ")
+}
+
+// expandValue expands s. lookup returns the value of a name; tildes are
+// expanded at the start and, with list, after every colon.
+func expandValue(s string, list bool, lookup func(name string) (string, error)) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '~' && (i == 0 || (list && s[i-1] == ':')) && (i+1 == len(s) || s[i+1] == '/' || (list && s[i+1] == ':')):
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", &expandError{i, fmt.Errorf("cannot expand ~: %w", err)}
+			}
+			b.WriteString(home)
+			i++
+
+		case c == '\\' && i+1 < len(s) && s[i+1] == '$':
+			b.WriteByte('$')
+			i += 2
+
+		case c == '$' && i+1 < len(s) && s[i+1] == '{':
+			end := matchingBrace(s, i+1)
+			if end < 0 {
+				return "", &expandError{i, fmt.Errorf("unterminated %s", s[i:])}
+			}
+			value, err := expandBraced(s[i+2:end], list, lookup)
+			if err != nil {
+				err.offset += i + 2
+				return "", err
+			}
+			b.WriteString(value)
+			i = end + 1
+
+		case c == '$' && i+1 < len(s) && isNameByte(s[i+1]):
+			j := i + 1
+			for j < len(s) && isNameByte(s[j]) {
+				j++
+			}
+			value, err := lookup(s[i+1 : j])
+			if err != nil {
+				return "", &expandError{i, err}
+			}
+			b.WriteString(value)
+			i = j
+
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), nil
+}
+
+// expandBraced expands the inside of ${...}: NAME, NAME:-word or NAME:?word
+// (the colon is optional, as unset and empty are the same here).
+func expandBraced(expr string, list bool, lookup func(name string) (string, error)) (string, *expandError) {
+	j := 0
+	for j < len(expr) && isNameByte(expr[j]) {
+		j++
+	}
+	name, op := expr[:j], strings.TrimPrefix(expr[j:], ":")
+	if name == "" {
+		return "", &expandError{0, fmt.Errorf("bad substitution ${%s}", expr)}
+	}
+	value, err := lookup(name)
+	if err != nil {
+		return "", &expandError{0, err}
+	}
+	switch {
+	case j == len(expr):
+		return value, nil
+	case strings.HasPrefix(op, "-"):
+		if value != "" {
+			return value, nil
+		}
+		word := op[1:]
+		expanded, err := expandValue(word, list, lookup)
+		if err != nil {
+			e := err.(*expandError)
+			e.offset += len(expr) - len(word)
+			return "", e
+		}
+		return expanded, nil
+	case strings.HasPrefix(op, "?"):
+		if value != "" {
+			return value, nil
+		}
+		if msg := op[1:]; msg != "" {
+			return "", &expandError{0, fmt.Errorf("%s: %s", name, msg)}
+		}
+		return "", &expandError{0, fmt.Errorf("%s is not set", name)}
+	}
+	return "", &expandError{0, fmt.Errorf("bad substitution ${%s}", expr)}
+}
+
+// matchingBrace returns the index of the } closing the { at open, or -1.
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expander resolves the entries of one user.dirs against each other, the
+// inherited environment and the defaults, under a policy.
+type expander struct {
+	file      *UserDirsFile
+	policy    Policy
+	inherited map[string]string
+	defaults  map[string]string
+
+	byKey  map[string][]int // entry indices per key, in file order
+	refs   map[int][]Ref    // variables each entry read, in order
+	values map[int]string
+	errs   map[int]error
+	active map[int]bool // being expanded, to detect cycles
+	stack  []string
+}
+
+func newExpander(file *UserDirsFile, policy Policy, inherited, defaults map[string]string) *expander {
+	e := &expander{
+		file: file, policy: policy, inherited: inherited, defaults: defaults,
+		byKey:  make(map[string][]int),
+		refs:   make(map[int][]Ref),
+		values: make(map[int]string),
+		errs:   make(map[int]error),
+		active: make(map[int]bool),
+	}
+	for i, entry := range file.Entries {
+		e.byKey[entry.Key] = append(e.byKey[entry.Key], i)
+	}
+	return e
+}
+
+// entry returns the expanded value of entry i.
+func (e *expander) entry(i int) (string, error) {
+	if value, ok := e.values[i]; ok {
+		return value, nil
+	}
+	if err, ok := e.errs[i]; ok {
+		return "", err
+	}
+	entry := e.file.Entries[i]
+	if e.active[i] {
+		chain := append(append([]string(nil), e.stack[e.indexOf(entry.Key):]...), entry.Key)
+		return "", &cycleError{chain}
+	}
+	if entry.Quote == '\'' {
+		e.values[i] = entry.Raw // single quotes are literal
+		return entry.Raw, nil
+	}
+
+	e.active[i] = true
+	e.stack = append(e.stack, entry.Key)
+	value, err := expandValue(entry.Raw, IsSearchPath(entry.Key), func(name string) (string, error) {
+		var value string
+		var src Source
+		var err error
+		switch _, managed := e.defaults[name]; {
+		case name == entry.Key:
+			value, src, err = e.before(i)
+		case managed || len(e.byKey[name]) > 0 || name == "XDG_RUNTIME_DIR":
+			value, src, err = e.merged(name, true)
+		default:
+			value, src = os.Getenv(name), SourceEnv
+		}
+		if err == nil {
+			e.record(i, Ref{Name: name, Value: value, Source: src})
+		}
+		return value, err
+	})
+	e.stack = e.stack[:len(e.stack)-1]
+	delete(e.active, i)
+
+	if err != nil {
+		e.errs[i] = err
+		return "", err
+	}
+	if IsSearchPath(entry.Key) {
+		value = normalizeSearchPath(value)
+	}
+	e.values[i] = value
+	return value, nil
+}
+
+// record notes that entry i read ref, once per name.
+func (e *expander) record(i int, ref Ref) {
+	for _, r := range e.refs[i] {
+		if r.Name == ref.Name {
+			return
+		}
+	}
+	e.refs[i] = append(e.refs[i], ref)
+}
+
+// before returns the value of entry i's key as it stood before line i, and
+// its source: the previous entry for the key, or else what the other layers
+// give it.
+func (e *expander) before(i int) (string, Source, error) {
+	key := e.file.Entries[i].Key
+	indices := e.byKey[key]
+	for n := len(indices) - 1; n >= 0; n-- {
+		if indices[n] < i {
+			value, err := e.entry(indices[n])
+			return value, SourceUser, err
+		}
+	}
+	return e.merged(key, false)
+}
+
+// user returns the value user.dirs gives key, the last entry for it, and the
+// entry's index, or -1 if there is none.
+func (e *expander) user(key string) (string, int, error) {
+	indices := e.byKey[key]
+	if len(indices) == 0 {
+		return "", -1, nil
+	}
+	last := indices[len(indices)-1]
+	value, err := e.entry(last)
+	return value, last, err
+}
+
+// merged returns the first non-empty value of key in its policy order and
+// the source it came from, leaving out user.dirs unless withUser.
+func (e *expander) merged(key string, withUser bool) (string, Source, error) {
+	for _, src := range e.policy.OrderFor(key) {
+		var value string
+		switch src {
+		case SourceEnv:
+			value = e.inherited[key]
+		case SourceUser:
+			if !withUser {
+				continue
+			}
+			v, i, err := e.user(key)
+			var cycle *cycleError
+			if errors.As(err, &cycle) {
+				return "", "", cycle
+			}
+			if err != nil {
+				return "", "", fmt.Errorf("%s (line %d) cannot be expanded", key, e.file.Entries[i].Line)
+			}
+			value = v
+		case SourceDefault:
+			value = e.defaults[key]
+		}
+		if value != "" {
+			return value, src, nil
+		}
+	}
+	return "", "", nil
+}
+
+func (e *expander) indexOf(key string) int {
+	for i, k := range e.stack {
+		if k == key {
+			return i
+		}
+	}
+	return 0
+}
+
+// diagnostic describes the failure of entry i, located at the offending
+// reference when known.
+func (e *expander) diagnostic(i int, err error) Diagnostic {
+	entry := e.file.Entries[i]
+	column := entry.Column
+	if entry.Quote != 0 {
+		column++
+	}
+	var expandErr *expandError
+	if errors.As(err, &expandErr) {
+		column += expandErr.offset
+	}
+	return Diagnostic{
+		File: e.file.Path, Line: entry.Line, Column: column, Severity: SeverityError,
+		Message: fmt.Sprintf("cannot expand %s: %v", entry.Key, err),
+	}
+}
@@ -0,0 +1,205 @@
+package xdgdirs
+
+// Rationale:
+// Earlier versions moved user-dirs.dirs away and started from the defaults,
+// so a machine with Downloads in $HOME/dl silently got ~/Downloads back. The
+// directories of a user-dirs.dirs that xdg-dirs did not write are now carried
+// over into user.dirs, once: automatically on the first export, before the
+// merge, or on demand with `xdg-dirs import`, which can preview the change
+// with --dry-run. Only values that differ from the defaults are imported, and
+// never over a line user.dirs already has. When user-dirs.dirs has already
+// been replaced, the backup the replacement left is read instead.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ImportEntry is one directory of the legacy file.
+type ImportEntry struct {
+	Key     string
+	Value   string // expanded
+	Default string // "" for variables without a default
+	Line    int
+}
+
+// ImportPlan is what importing the legacy file changes in user.dirs.
+type ImportPlan struct {
+	Source  string        // the file read, "" if there is none
+	Entries []ImportEntry // to be added to user.dirs
+	Kept    []ImportEntry // differing, but already set in user.dirs
+}
+
+// StateDir is where xdg-dirs keeps its state, under the merged
+// XDG_STATE_HOME: the same directory whichever command asks, and whatever
+// the calling shell exported.
+func (x *XDGDirs) StateDir() (string, error) {
+	stateHome, err := x.mergedDir("XDG_STATE_HOME")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateHome, "xdg-dirs"), nil
+}
+
+// importSource returns the file to import from: user-dirs.dirs unless
+// xdg-dirs wrote it (in the merged XDG_CONFIG_HOME or next to the xdg
+// folder), else the latest backup of the one it replaced, else the
+// user-dirs.dirs-backup older versions left in the xdg folder, else "".
+func (x *XDGDirs) importSource() (string, []byte, error) {
+	legacy, err := x.LegacyUserDirsPath()
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := x.configDir()
+	if err != nil {
+		return "", nil, err
+	}
+	candidates := []string{legacy}
+	// Where xdg-user-dirs wrote it before user.dirs moved XDG_CONFIG_HOME.
+	if beside := filepath.Join(filepath.Dir(dir), "user-dirs.dirs"); beside != legacy {
+		candidates = append(candidates, beside)
+	}
+	if b, ok, err := x.LatestBackup(BackupUserDirs); err != nil {
+		return "", nil, err
+	} else if ok {
+		candidates = append(candidates, b.Path)
+	}
+	candidates = append(candidates, filepath.Join(dir, "user-dirs.dirs-backup"))
+
+	for _, path := range candidates {
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if strings.HasPrefix(string(content), compatHeader) {
+			continue
+		}
+		return path, content, nil
+	}
+	return "", nil, nil
+}
+
+// PlanImport reads the legacy user-dirs.dirs and returns the directories
+// that differ from the defaults.
+func (x *XDGDirs) PlanImport() (*ImportPlan, error) {
+	path, content, err := x.importSource()
+	if err != nil || path == "" {
+		return &ImportPlan{}, err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	user, err := x.ParseUserDirs()
+	if err != nil {
+		return nil, err
+	}
+	inUser := make(map[string]bool, len(user.Entries))
+	for _, entry := range user.Entries {
+		inUser[entry.Key] = true
+	}
+
+	plan := &ImportPlan{Source: path}
+	for _, entry := range x.legacyEntries(path, content, home) {
+		if entry.Default != "" && filepath.Clean(entry.Default) == entry.Value {
+			continue
+		}
+		if inUser[entry.Key] {
+			plan.Kept = append(plan.Kept, entry)
+		} else {
+			plan.Entries = append(plan.Entries, entry)
+		}
+	}
+	sort.Slice(plan.Entries, func(i, j int) bool { return plan.Entries[i].Key < plan.Entries[j].Key })
+	sort.Slice(plan.Kept, func(i, j int) bool { return plan.Kept[i].Key < plan.Kept[j].Key })
+	return plan, nil
+}
+
+// legacyEntries returns the directories of a file in the user-dirs.dirs
+// format, sorted by key; for a key set twice, the last line wins.
+func (x *XDGDirs) legacyEntries(path string, content []byte, home string) []ImportEntry {
+	defaults := defaultDirs(home)
+	byKey := make(map[string]ImportEntry)
+	for _, entry := range ParseUserDirs(path, content).Entries {
+		if !userDirKey.MatchString(entry.Key) || entry.Key == "XDG_RUNTIME_DIR" {
+			continue
+		}
+		value, ok := legacyValue(entry.Raw, home)
+		if !ok {
+			x.logger.With("path", path, "line", entry.Line).Debug("Not importing %s=%q: not in the user-dirs.dirs format", entry.Key, entry.Raw)
+			continue
+		}
+		byKey[entry.Key] = ImportEntry{Key: entry.Key, Value: value, Default: defaults[entry.Key], Line: entry.Line}
+	}
+	entries := make([]ImportEntry, 0, len(byKey))
+	for _, entry := range byKey {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// legacyValue expands a value in the only two forms user-dirs.dirs has,
+// "$HOME/yyy" and "/yyy".
+func legacyValue(raw, home string) (string, bool) {
+	value := strings.NewReplacer(`\$`, "$", "\\`", "`").Replace(raw)
+	for _, prefix := range []string{"$HOME", "${HOME}"} {
+		if value == prefix || strings.HasPrefix(value, prefix+"/") {
+			value = home + value[len(prefix):]
+			break
+		}
+	}
+	if !filepath.IsAbs(value) {
+		return "", false
+	}
+	return filepath.Clean(value), true
+}
+
+// ApplyImport adds the entries of plan to user.dirs.
+func (x *XDGDirs) ApplyImport(plan *ImportPlan) error {
+	for _, entry := range plan.Entries {
+		if err := x.SetUserDir(entry.Key, entry.Value); err != nil {
+			return fmt.Errorf("failed to import %s: %w", entry.Key, err)
+		}
+	}
+	return x.markImported()
+}
+
+// importMarker records that the automatic import has run.
+func (x *XDGDirs) importMarker() (string, error) {
+	dir, err := x.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "imported"), nil
+}
+
+// Imported reports whether the one-time import has already run.
+func (x *XDGDirs) Imported() (bool, error) {
+	marker, err := x.importMarker()
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(marker)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (x *XDGDirs) markImported() error {
+	marker, err := x.importMarker()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(marker), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(marker), err)
+	}
+	return writeFileAtomic(marker, nil, 0644)
+}
@@ -0,0 +1,336 @@
+package xdgdirs
+
+// Rationale:
+// user.dirs used to be read with "starts with XDG_ and contains =", so a typo
+// (XGD_CACHE_HOME) or a missing quote silently lost the line and the default
+// won without a trace. The parser below reads every line and reports what it
+// could not make sense of with file:line:column, the way compilers do, so the
+// message can be pasted into an editor. Errors are lines that are not valid
+// syntax and are skipped; warnings are valid lines that are probably wrong.
+// With --strict both are fatal; otherwise they are logged and the run goes on.
+//
+// Syntax, one assignment per line, as written by xdg-user-dirs:
+//
+//	# comment
+//	XDG_CACHE_HOME="$HOME/.local/cache"   # trailing comment
+//	XDG_MUSIC_DIR=/data/music
+//	XDG_NOTES_DIR='$literally/not/expanded'
+//	@precedence="env,user,default"
+//	@extras="XDG_BIN_HOME XDG_PROJECTS_DIR"
+//	@apps="cargo gnupg less"
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Severity ranks a Diagnostic.
+type Severity string
+
+const (
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// Diagnostic is a problem in user.dirs. Line and Column are 1-based; Column
+// counts bytes.
+type Diagnostic struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+// ParseError is returned in strict mode when user.dirs has any diagnostic.
+type ParseError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	lines := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		lines[i] = d.String()
+	}
+	return fmt.Sprintf("user.dirs has %d problem(s) (strict mode):\n%s", len(e.Diagnostics), strings.Join(lines, "\n"))
+}
+
+// Entry is one variable assignment, unexpanded. Quote is the quote character
+// the value was written with, or 0.
+type Entry struct {
+	Key       string
+	Raw       string
+	Quote     byte
+	Comment   string // trailing comment, without the #
+	Line      int
+	KeyColumn int
+	Column    int // of the value
+}
+
+// UserDirsFile is a parsed user.dirs: the assignments that are valid syntax,
+// the policy and extras its directives define, and everything worth
+// reporting.
+type UserDirsFile struct {
+	Path        string
+	Entries     []Entry
+	Policy      Policy
+	Extras      []Entry // names listed in @extras, with their position
+	Apps        []Entry // names listed in @apps, likewise
+	Directives  []Entry // every @ line, by name
+	Diagnostics []Diagnostic
+}
+
+// ParseUserDirs parses the content of the user.dirs at path.
+func ParseUserDirs(path string, content []byte) *UserDirsFile {
+	f := &UserDirsFile{Path: path, Policy: DefaultPolicy()}
+	report := func(severity Severity, line, column int, format string, v ...interface{}) {
+		f.Diagnostics = append(f.Diagnostics, Diagnostic{
+			File: path, Line: line, Column: column, Severity: severity, Message: fmt.Sprintf(format, v...),
+		})
+	}
+
+	definedOn := make(map[string]int)
+	for i, text := range strings.Split(string(content), "\n") {
+		n := i + 1
+		s, ok := scanLine(strings.TrimSuffix(text, "\r"))
+		if !ok {
+			continue
+		}
+		if s.err != "" {
+			report(SeverityError, n, s.errCol, "%s", s.err)
+			continue
+		}
+		if s.trailing != "" {
+			report(SeverityError, n, s.trailingCol, "unexpected text after the value: %q", s.trailing)
+			continue
+		}
+
+		if strings.HasPrefix(s.name, "@") {
+			f.Directives = append(f.Directives, Entry{Key: s.name, Raw: s.value, Line: n, KeyColumn: s.nameCol})
+		}
+		if s.name == "@extras" {
+			f.Extras = append(f.Extras, listFields(s, n)...)
+			continue
+		}
+		if s.name == "@apps" {
+			f.Apps = append(f.Apps, listFields(s, n)...)
+			continue
+		}
+		if strings.HasPrefix(s.name, "@") {
+			if err := f.Policy.applyDirective(s.name, s.value); err != nil {
+				report(SeverityWarning, n, s.nameCol, "ignoring directive: %v", err)
+			}
+			continue
+		}
+
+		if s.blankCol != 0 {
+			report(SeverityWarning, n, s.blankCol, "unquoted value %q contains blanks; quote it", s.value)
+		}
+		if !validKey.MatchString(s.name) {
+			report(SeverityWarning, n, s.nameCol, "unknown key %s, line ignored%s", s.name, suggestKey(s.name, KnownKeys()))
+			continue
+		}
+		if prev, ok := definedOn[s.name]; ok && !(IsSearchPath(s.name) && refersTo(s.value, s.name)) {
+			report(SeverityWarning, n, s.nameCol, "duplicate key %s, already set on line %d; this line wins", s.name, prev)
+		}
+		definedOn[s.name] = n
+		f.Entries = append(f.Entries, Entry{
+			Key: s.name, Raw: s.value, Quote: s.quote, Comment: s.comment,
+			Line: n, KeyColumn: s.nameCol, Column: s.valueCol,
+		})
+	}
+	return f
+}
+
+// listFields splits the value of a list directive such as @extras into one
+// entry per name, each at its own column. The search for a name starts after
+// the previous one, so a repeated name, or one that begins an earlier name,
+// is not placed on the earlier one.
+func listFields(s scanned, line int) []Entry {
+	var entries []Entry
+	offset := 0
+	for _, field := range strings.Fields(s.value) {
+		offset += strings.Index(s.value[offset:], field)
+		col := s.valueCol + offset
+		if s.quote != 0 {
+			col++
+		}
+		entries = append(entries, Entry{Key: field, Line: line, KeyColumn: col})
+		offset += len(field)
+	}
+	return entries
+}
+
+// ParseUserDirs reads and parses user.dirs. A missing file parses as empty.
+func (x *XDGDirs) ParseUserDirs() (*UserDirsFile, error) {
+	path, err := x.UserDirsPath()
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return ParseUserDirs(path, content), nil
+}
+
+// scanned is one user.dirs line split into its parts. Columns are 1-based.
+type scanned struct {
+	name      string
+	nameCol   int
+	hasEquals bool
+
+	value    string
+	valueCol int
+	quote    byte
+	blankCol int // of the first blank in an unquoted value, or 0
+
+	trailing    string
+	trailingCol int
+	comment     string
+
+	err    string
+	errCol int
+}
+
+// scanLine splits a line into name and value. ok is false for blank lines
+// and comments. Inside double quotes, \" and \\ stand for " and \; other
+// backslashes are kept for the expansion. Single quotes are literal.
+func scanLine(text string) (s scanned, ok bool) {
+	i := skipBlanks(text, 0)
+	if i == len(text) || text[i] == '#' {
+		return s, false
+	}
+
+	start := i
+	directive := text[i] == '@'
+	if directive {
+		i++
+	}
+	for i < len(text) && (isNameByte(text[i]) || (directive && text[i] == '.')) {
+		i++
+	}
+	s.name, s.nameCol = text[start:i], start+1
+	if s.name == "" || s.name == "@" {
+		s.err, s.errCol = fmt.Sprintf("expected a variable name, found %q", text[start:]), start+1
+		return s, true
+	}
+
+	i = skipBlanks(text, i)
+	if i == len(text) || text[i] != '=' {
+		s.err, s.errCol = fmt.Sprintf("expected '=' after %s", s.name), i+1
+		return s, true
+	}
+	s.hasEquals = true
+	i = skipBlanks(text, i+1)
+
+	s.valueCol = i + 1
+	if i < len(text) && (text[i] == '"' || text[i] == '\'') {
+		s.quote = text[i]
+		var b strings.Builder
+		closed := false
+		for i++; i < len(text); i++ {
+			c := text[i]
+			if c == s.quote {
+				closed = true
+				i++
+				break
+			}
+			if s.quote == '"' && c == '\\' && i+1 < len(text) && (text[i+1] == '"' || text[i+1] == '\\') {
+				i++
+				c = text[i]
+			}
+			b.WriteByte(c)
+		}
+		if !closed {
+			s.err, s.errCol = fmt.Sprintf("unterminated %c quote", s.quote), s.valueCol
+			return s, true
+		}
+		s.value = b.String()
+	} else {
+		// Unquoted values run up to a comment, blanks included, as they
+		// always have; the parser warns about the blanks.
+		start := i
+		for i < len(text) && text[i] != '#' {
+			i++
+		}
+		s.value = strings.TrimRight(text[start:i], " \t")
+		if blank := strings.IndexAny(s.value, " \t"); blank >= 0 {
+			s.blankCol = s.valueCol + blank
+		}
+	}
+
+	i = skipBlanks(text, i)
+	switch {
+	case i == len(text):
+	case text[i] == '#':
+		s.comment = strings.TrimSpace(text[i+1:])
+	default:
+		s.trailing, s.trailingCol = strings.TrimRight(text[i:], " \t"), i+1
+	}
+	return s, true
+}
+
+func skipBlanks(text string, i int) int {
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+// refersTo reports whether value mentions $key or ${key}, the way a search
+// path line extends the list instead of replacing it.
+func refersTo(value, key string) bool {
+	return strings.Contains(value, "$"+key) || strings.Contains(value, "${"+key)
+}
+
+// suggestKey returns ` (did you mean XDG_CACHE_HOME?)` when name is a small
+// typo away from one of known, or "".
+func suggestKey(name string, known []string) string {
+	upper := strings.ToUpper(name)
+	best, bestDistance := "", 3
+	for _, key := range known {
+		if d := editDistance(upper, key); d < bestDistance {
+			best, bestDistance = key, d
+		}
+	}
+	if best == "" || best == name {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// editDistance is the Levenshtein distance between a and b, where swapping
+// two adjacent letters counts as one edit.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
@@ -0,0 +1,104 @@
+package xdgdirs
+
+// Rationale:
+// A value can come from three places: the environment we inherited, the
+// user's user.dirs, and the spec defaults. Which one wins is a policy, not a
+// constant: a container runtime may set XDG_CACHE_HOME on purpose, and
+// XDG_RUNTIME_DIR is provided by the system and must survive. The default
+// policy is the historical behaviour (user.dirs over defaults, inherited
+// values ignored) except for XDG_RUNTIME_DIR, where the environment wins.
+// The inherited search paths need no entry here: they are the baseline a
+// user.dirs value extends through $XDG_DATA_DIRS (see ResolveAll).
+//
+// In user.dirs:
+//
+//	@precedence="env,user,default"                 # for every variable
+//	@precedence.XDG_CACHE_HOME="env,user,default"  # for one variable
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Source is one layer a value can come from.
+type Source string
+
+const (
+	SourceEnv     Source = "env"
+	SourceUser    Source = "user"
+	SourceDefault Source = "default"
+)
+
+// Policy orders the sources, globally and per variable.
+type Policy struct {
+	Order  []Source
+	PerKey map[string][]Source
+}
+
+// DefaultPolicy returns user.dirs over defaults, with the inherited
+// XDG_RUNTIME_DIR winning over both.
+func DefaultPolicy() Policy {
+	return Policy{
+		Order: []Source{SourceUser, SourceDefault},
+		PerKey: map[string][]Source{
+			"XDG_RUNTIME_DIR": {SourceEnv, SourceUser, SourceDefault},
+		},
+	}
+}
+
+// OrderFor returns the order that applies to key.
+func (p Policy) OrderFor(key string) []Source {
+	if order, ok := p.PerKey[key]; ok {
+		return order
+	}
+	return p.Order
+}
+
+// ParseOrder reads an order such as "env,user,default". Sources left out are
+// never consulted.
+func ParseOrder(s string) ([]Source, error) {
+	var order []Source
+	seen := make(map[Source]bool)
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		src := Source(strings.ToLower(field))
+		switch src {
+		case SourceEnv, SourceUser, SourceDefault:
+		default:
+			return nil, fmt.Errorf("unknown source %q (want env, user or default)", field)
+		}
+		if seen[src] {
+			return nil, fmt.Errorf("source %q listed twice", field)
+		}
+		seen[src] = true
+		order = append(order, src)
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("empty precedence order")
+	}
+	return order, nil
+}
+
+// applyDirective applies one @ directive of user.dirs, already split into
+// name and unquoted value by the parser, to p.
+func (p *Policy) applyDirective(name, value string) error {
+	key, perKey := strings.CutPrefix(name, "@precedence.")
+	if name != "@precedence" && !(perKey && validKey.MatchString(key)) {
+		return fmt.Errorf("unknown directive %s", name)
+	}
+	order, err := ParseOrder(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if !perKey {
+		p.Order = order
+		return nil
+	}
+	orders := make(map[string][]Source, len(p.PerKey)+1)
+	for k, v := range p.PerKey {
+		orders[k] = v
+	}
+	orders[key] = order
+	p.PerKey = orders
+	return nil
+}
@@ -0,0 +1,107 @@
+package xdgdirs
+
+// Rationale:
+// The spec stops at the variables in getDefaultXDGDirs, but a few more are
+// common enough that people set them by hand, each with their own idea of
+// the default: XDG_BIN_HOME (systemd's file-hierarchy), XDG_PROJECTS_DIR,
+// XDG_SCREENSHOTS_DIR. The registry gives them one default and a description.
+// They are NOT exported by default - that would change every existing setup
+// - but only once user.dirs assigns them or lists them in @extras:
+//
+//	@extras="XDG_BIN_HOME XDG_SCREENSHOTS_DIR"
+//
+// Organizations add their own keys in extras.dirs next to user.dirs, in the
+// same syntax, with the trailing comment as the description:
+//
+//	XDG_ACME_DIR="~/Acme"  # ACME shared drive
+//
+// Variables that are neither standard nor registered are still passed
+// through, but flagged: they are usually typos.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Extra is a registered non-standard variable.
+type Extra struct {
+	Key         string
+	Default     string
+	Description string
+	Origin      string // "built-in" or the extras.dirs that defines it
+}
+
+// builtinExtras returns the extras every installation knows about.
+func builtinExtras(home string) []Extra {
+	return []Extra{
+		{"XDG_BIN_HOME", filepath.Join(home, ".local", "bin"), "User executables, as in systemd's file-hierarchy(7)", "built-in"},
+		{"XDG_PROJECTS_DIR", filepath.Join(home, "Projects"), "Source code and project checkouts", "built-in"},
+		{"XDG_SCREENSHOTS_DIR", filepath.Join(home, "Pictures", "Screenshots"), "Screenshots, as used by GNOME and KDE", "built-in"},
+	}
+}
+
+// ExtrasPath is the location of extras.dirs, the organization's registry.
+func (x *XDGDirs) ExtrasPath() (string, error) {
+	dir, err := x.configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "extras.dirs"), nil
+}
+
+// Registry returns the built-in extras followed by those of extras.dirs,
+// sorted by key, and the problems found in extras.dirs. Defaults in
+// extras.dirs are expanded against the standard defaults.
+func (x *XDGDirs) Registry() ([]Extra, []Diagnostic, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	registry := make(map[string]Extra)
+	for _, extra := range builtinExtras(home) {
+		registry[extra.Key] = extra
+	}
+
+	path, err := x.ExtrasPath()
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	file := ParseUserDirs(path, content)
+	diagnostics := file.Diagnostics
+	report := func(line, column int, format string, v ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{
+			File: path, Line: line, Column: column, Severity: SeverityWarning, Message: fmt.Sprintf(format, v...),
+		})
+	}
+	for _, directive := range file.Directives {
+		report(directive.Line, directive.KeyColumn, "%s has no effect in extras.dirs; it belongs in user.dirs", directive.Key)
+	}
+
+	defaults := defaultDirs(home)
+	e := newExpander(file, DefaultPolicy(), nil, defaults)
+	for i, entry := range file.Entries {
+		if _, standard := defaults[entry.Key]; standard || entry.Key == "XDG_RUNTIME_DIR" {
+			report(entry.Line, entry.KeyColumn, "%s is a standard variable, not an extra", entry.Key)
+			continue
+		}
+		value, err := e.entry(i)
+		if err != nil {
+			diagnostics = append(diagnostics, e.diagnostic(i, err))
+			continue
+		}
+		registry[entry.Key] = Extra{Key: entry.Key, Default: value, Description: entry.Comment, Origin: path}
+	}
+
+	extras := make([]Extra, 0, len(registry))
+	for _, extra := range registry {
+		extras = append(extras, extra)
+	}
+	sort.Slice(extras, func(i, j int) bool { return extras[i].Key < extras[j].Key })
+	return extras, diagnostics, nil
+}
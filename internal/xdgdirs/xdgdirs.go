@@ -1,7 +1,9 @@
 package xdgdirs
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -10,6 +12,7 @@ import (
 
 	"runtime"
 
+	"github.com/adriangalilea/xdg-dirs/internal/fslock"
 	"github.com/adriangalilea/xdg-dirs/internal/logger"
 )
 
@@ -17,6 +20,12 @@ type XDGDirs struct {
 	logger *logger.Logger
 	mu     sync.Mutex
 	Dirs   map[string]string
+
+	inherited  map[string]string
+	precedence []Source
+	strict     bool
+	report     io.Writer         // where Resolve reports diagnostics instead of the log
+	merged     map[string]string // values of the last merge, see mergedDir
 }
 
 func init() {
@@ -35,7 +44,9 @@ func NewXDGDirs(log *logger.Logger) *XDGDirs {
 // ("Application Support" for everything) was exactly what it replaces, and
 // shipping that opinion as the fallback made a fresh machine diverge from
 // the fleet the moment no user.dirs existed. Native mappings are an OPT-IN
-// via user.dirs, never a default. XDG_RUNTIME_DIR is deliberately absent:
+// via user.dirs, never a default. The search paths carry the spec defaults
+// too, used only when the environment provides none (see ResolveAll).
+// XDG_RUNTIME_DIR is deliberately absent:
 // the spec says the SYSTEM provides it (lifetime + permission semantics no
 // user tool can fake); set it in user.dirs if you must.
 func getDefaultXDGDirs() map[string]string {
@@ -43,6 +54,10 @@ func getDefaultXDGDirs() map[string]string {
 	if err != nil {
 		panic(fmt.Sprintf("failed to get user home directory: %v", err))
 	}
+	return defaultDirs(home)
+}
+
+func defaultDirs(home string) map[string]string {
 	videos := filepath.Join(home, "Videos")
 	if runtime.GOOS == "darwin" {
 		videos = filepath.Join(home, "Movies")
@@ -60,115 +75,493 @@ func getDefaultXDGDirs() map[string]string {
 		"XDG_VIDEOS_DIR":      videos,
 		"XDG_TEMPLATES_DIR":   filepath.Join(home, "Templates"),
 		"XDG_PUBLICSHARE_DIR": filepath.Join(home, "Public"),
+		"XDG_DATA_DIRS":       "/usr/local/share:/usr/share",
+		"XDG_CONFIG_DIRS":     "/etc/xdg",
 	}
 }
 
-func (x *XDGDirs) ReadUserDirs() (map[string]string, error) {
-	userDirs := getDefaultXDGDirs()
-	x.mu.Lock()
-	defer x.mu.Unlock()
+// IsSearchPath reports whether key is one of the colon-separated search path
+// lists rather than a single directory.
+func IsSearchPath(key string) bool {
+	return key == "XDG_DATA_DIRS" || key == "XDG_CONFIG_DIRS"
+}
+
+// normalizeSearchPath drops empty entries and later duplicates, keeping the
+// order of first appearance.
+func normalizeSearchPath(list string) string {
+	seen := make(map[string]bool)
+	var entries []string
+	for _, entry := range strings.Split(list, ":") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if clean := filepath.Clean(entry); !seen[clean] {
+			seen[clean] = true
+			entries = append(entries, entry)
+		}
+	}
+	return strings.Join(entries, ":")
+}
 
+// configDir is the directory holding user.dirs and generated.dirs.
+func (x *XDGDirs) configDir() (string, error) {
 	configHome := os.Getenv("XDG_CONFIG_HOME")
 	if configHome == "" {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
 			x.logger.Error("Failed to get user home directory: %v", err)
-			return nil, fmt.Errorf("failed to get user home directory: %w", err)
+			return "", fmt.Errorf("failed to get user home directory: %w", err)
 		}
 		configHome = filepath.Join(homeDir, ".config")
 	}
-	userDirsPath := filepath.Join(configHome, "xdg", "user.dirs")
+	return filepath.Join(configHome, "xdg"), nil
+}
 
-	if _, err := os.Stat(userDirsPath); err == nil {
-		content, err := os.ReadFile(userDirsPath)
+// mergedDir returns the directory the merge gives key, such as
+// XDG_CONFIG_HOME or XDG_STATE_HOME. The files xdg-dirs writes for others go
+// where the exported variables point, not where the environment it was
+// started from did (setup unsets it anyway). The merge runs once if nothing
+// has resolved yet; a value that is not absolute falls back to the default.
+func (x *XDGDirs) mergedDir(key string) (string, error) {
+	x.mu.Lock()
+	value, ok := x.merged[key]
+	x.mu.Unlock()
+	if !ok {
+		origins, _, err := x.ResolveAll()
+		if err != nil {
+			return "", err
+		}
+		value = origins[key].Value
+	}
+	if !filepath.IsAbs(value) {
+		home, err := os.UserHomeDir()
 		if err != nil {
-			x.logger.Error("Failed to read user.dirs file: %v", err)
-			return nil, fmt.Errorf("failed to read user.dirs file: %w", err)
-		}
-
-		x.logger.Debug("Contents of %s:\n%s", userDirsPath, string(content))
-
-		lines := strings.Split(string(content), "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "XDG_") && strings.Contains(line, "=") {
-				parts := strings.SplitN(line, "=", 2)
-				if len(parts) == 2 {
-					key := strings.TrimSpace(parts[0])
-					value := strings.TrimSpace(parts[1])
-					// Strip inline comments
-					if idx := strings.Index(value, "#"); idx != -1 {
-						value = strings.TrimSpace(value[:idx])
-					}
-					value = strings.Trim(value, "\"")
-					value = os.ExpandEnv(value) // Expand environment variables
-					userDirs[key] = value
-				}
+			return "", fmt.Errorf("failed to get user home directory: %w", err)
+		}
+		value = defaultDirs(home)[key]
+	}
+	return value, nil
+}
+
+// UserDirsPath is the location of the user-editable user.dirs file.
+func (x *XDGDirs) UserDirsPath() (string, error) {
+	dir, err := x.configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "user.dirs"), nil
+}
+
+// GeneratedDirsPath is the location of generated.dirs.
+func (x *XDGDirs) GeneratedDirsPath() (string, error) {
+	dir, err := x.configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "generated.dirs"), nil
+}
+
+// KnownKeys returns the variables xdg-dirs knows without any configuration,
+// sorted: those with a default, XDG_RUNTIME_DIR and the built-in extras.
+func KnownKeys() []string {
+	keys := []string{"XDG_RUNTIME_DIR"}
+	for key := range defaultDirs("/") {
+		keys = append(keys, key)
+	}
+	for _, extra := range builtinExtras("/") {
+		keys = append(keys, extra.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetInherited gives the merge the environment values captured by
+// setup.Prepare, for variables whose policy consults the environment.
+func (x *XDGDirs) SetInherited(env map[string]string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.inherited = env
+}
+
+// SetPrecedence overrides the global order of the policy, including one set
+// with @precedence in user.dirs. Per-variable orders still apply.
+func (x *XDGDirs) SetPrecedence(order []Source) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.precedence = order
+}
+
+// SetStrict makes any diagnostic in user.dirs fatal: Resolve returns a
+// *ParseError instead of logging them.
+func (x *XDGDirs) SetStrict(strict bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.strict = strict
+}
+
+// SetDiagnostics makes Resolve write the problems it finds in user.dirs to
+// w, one per line, instead of logging them: the commands that only read must
+// not create or write the log file.
+func (x *XDGDirs) SetDiagnostics(w io.Writer) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.report = w
+}
+
+// Origin records where a merged value came from: the winning source and, for
+// user.dirs, the file, line and text as written before expansion, and the
+// variables the expansion read. For the environment and the defaults Raw is
+// the value itself. Skipped lists the sources before the winner that had the
+// variable but an empty value, such as XDG_MUSIC_DIR="" in user.dirs.
+type Origin struct {
+	Key     string   `json:"key"`
+	Value   string   `json:"value"`
+	Source  Source   `json:"source"`
+	File    string   `json:"file,omitempty"`
+	Line    int      `json:"line,omitempty"`
+	Raw     string   `json:"raw"`
+	Refs    []Ref    `json:"refs,omitempty"`
+	Skipped []Origin `json:"skipped,omitempty"`
+}
+
+// Ref is a variable read by the expansion of a user.dirs value, with the
+// value it had and the source of that value: env for anything xdg-dirs does
+// not manage, such as $HOME.
+type Ref struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source Source `json:"source"`
+}
+
+// ReadUserDirs returns the merged value of every variable.
+func (x *XDGDirs) ReadUserDirs() (map[string]string, error) {
+	origins, err := x.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	userDirs := make(map[string]string, len(origins))
+	for key, origin := range origins {
+		userDirs[key] = origin.Value
+	}
+	return userDirs, nil
+}
+
+// Resolve merges the environment, user.dirs and the defaults under the
+// precedence policy, keeping the origin of every winning value. Problems in
+// user.dirs are logged (see SetDiagnostics), or returned as a *ParseError in
+// strict mode.
+func (x *XDGDirs) Resolve() (map[string]Origin, error) {
+	origins, diagnostics, err := x.ResolveAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(diagnostics) > 0 {
+		if x.isStrict() {
+			return nil, &ParseError{Diagnostics: diagnostics}
+		}
+		x.mu.Lock()
+		report := x.report
+		x.mu.Unlock()
+		for _, d := range diagnostics {
+			if report != nil {
+				fmt.Fprintf(report, "xdg-dirs: %s\n", d)
+				continue
 			}
+			x.logger.With("path", d.File, "line", d.Line, "column", d.Column).Warn("%s", d)
 		}
-	} else {
-		x.logger.Debug("user.dirs file not found at %s", userDirsPath)
 	}
+	return origins, nil
+}
+
+func (x *XDGDirs) isStrict() bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.strict
+}
 
-	// Merge user-defined directories with defaults, preferring user-defined values
-	defaults := getDefaultXDGDirs()
-	for key, defaultValue := range defaults {
-		if value, exists := userDirs[key]; !exists || value == "" {
-			userDirs[key] = defaultValue
+// ResolveAll is Resolve returning the problems in user.dirs, sorted by
+// position, instead of logging them. Lines with errors are left out of the
+// merge.
+func (x *XDGDirs) ResolveAll() (map[string]Origin, []Diagnostic, error) {
+	defaults := make(map[string]Origin)
+	for key, value := range getDefaultXDGDirs() {
+		defaults[key] = Origin{Key: key, Value: value, Source: SourceDefault, Raw: value}
+	}
+	userValues := make(map[string]Origin)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	// An inherited search path is what the system set up (NixOS profiles,
+	// Flatpak exports, the desktop session): it replaces the spec list as the
+	// baseline, so it is kept as is and $XDG_DATA_DIRS in user.dirs extends it.
+	for key, value := range x.inherited {
+		if IsSearchPath(key) && value != "" {
+			defaults[key] = Origin{Key: key, Value: value, Source: SourceEnv, Raw: value}
 		}
 	}
 
-	// Log all merged user directories
+	file, err := x.ParseUserDirs()
+	if err != nil {
+		x.logger.With("error", err).Error("Failed to read user.dirs file")
+		return nil, nil, err
+	}
+	extras, extraDiagnostics, err := x.Registry()
+	if err != nil {
+		x.logger.With("error", err).Error("Failed to read extras.dirs file")
+		return nil, nil, err
+	}
+	diagnostics := append(append([]Diagnostic(nil), file.Diagnostics...), extraDiagnostics...)
+	diagnostics = append(diagnostics, x.enableExtras(file, extras, defaults)...)
+	_, appDiagnostics := selectApps(file)
+	diagnostics = append(diagnostics, appDiagnostics...)
+	policy := file.Policy
+	if x.precedence != nil {
+		policy.Order = x.precedence
+	}
+
+	defaultValues := make(map[string]string, len(defaults))
+	for key, origin := range defaults {
+		defaultValues[key] = origin.Value
+	}
+	e := newExpander(file, policy, x.inherited, defaultValues)
+	for i := range file.Entries {
+		if _, err := e.entry(i); err != nil {
+			diagnostics = append(diagnostics, e.diagnostic(i, err))
+		}
+	}
+	for key := range e.byKey {
+		value, last, err := e.user(key)
+		if err != nil {
+			continue // reported above; the next layer wins
+		}
+		entry := file.Entries[last]
+		userValues[key] = Origin{
+			Key: key, Value: value, Source: SourceUser, File: file.Path, Line: entry.Line, Raw: entry.Raw, Refs: e.refs[last],
+		}
+	}
+	if len(file.Entries) == 0 {
+		x.logger.Debug("No entries in %s", file.Path)
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].File != diagnostics[j].File {
+			return diagnostics[i].File < diagnostics[j].File
+		}
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+		return diagnostics[i].Column < diagnostics[j].Column
+	})
+
+	inherited := make(map[string]Origin, len(x.inherited))
+	for key, value := range x.inherited {
+		inherited[key] = Origin{Key: key, Value: value, Source: SourceEnv, Raw: value}
+	}
+
+	// Merge the layers: for every variable, the first source in its order
+	// with a non-empty value wins; the empty ones before it are kept for
+	// explain.
+	layers := map[Source]map[string]Origin{
+		SourceEnv:     inherited,
+		SourceUser:    userValues,
+		SourceDefault: defaults,
+	}
+	keys := make(map[string]bool)
+	for _, layer := range layers {
+		for key := range layer {
+			keys[key] = true
+		}
+	}
+	origins := make(map[string]Origin, len(keys))
+	for key := range keys {
+		var skipped []Origin
+		for _, src := range policy.OrderFor(key) {
+			origin, ok := layers[src][key]
+			if !ok {
+				continue
+			}
+			if origin.Value == "" {
+				skipped = append(skipped, origin)
+				continue
+			}
+			origin.Skipped = skipped
+			origins[key] = origin
+			break
+		}
+	}
+
+	// Log all merged user directories with the source that won
 	var logEntries []string
-	for key, value := range userDirs {
-		logEntries = append(logEntries, fmt.Sprintf("%s=%s", key, value))
+	x.merged = make(map[string]string, len(origins))
+	for key, origin := range origins {
+		x.merged[key] = origin.Value
+		logEntries = append(logEntries, fmt.Sprintf("%s=%s (from %s)", key, origin.Value, origin.Source))
 	}
+	sort.Strings(logEntries)
 	x.logger.Debug("Merged user directories:\n%s", strings.Join(logEntries, "\n"))
-	return userDirs, nil
+	return origins, diagnostics, nil
 }
 
-func (x *XDGDirs) WriteUserDirs(userDirs map[string]string) error {
-	configHome := os.Getenv("XDG_CONFIG_HOME")
-	if configHome == "" {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			x.logger.Error("Failed to get user home directory: %v", err)
-			return fmt.Errorf("failed to get user home directory: %w", err)
+// enableExtras adds to defaults the registered extras that user.dirs assigns
+// or lists in @extras, and flags the variables that are neither standard nor
+// registered.
+func (x *XDGDirs) enableExtras(file *UserDirsFile, extras []Extra, defaults map[string]Origin) []Diagnostic {
+	var diagnostics []Diagnostic
+	report := func(line, column int, format string, v ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{
+			File: file.Path, Line: line, Column: column, Severity: SeverityWarning, Message: fmt.Sprintf(format, v...),
+		})
+	}
+
+	registered := make(map[string]Extra, len(extras))
+	known := KnownKeys()
+	for _, extra := range extras {
+		registered[extra.Key] = extra
+		known = append(known, extra.Key)
+	}
+	enabled := make(map[string]bool)
+	for _, entry := range file.Extras {
+		if _, ok := registered[entry.Key]; !ok {
+			report(entry.Line, entry.KeyColumn, "%s in @extras is not a registered extra%s", entry.Key, suggestKey(entry.Key, known))
+			continue
+		}
+		enabled[entry.Key] = true
+	}
+	for _, entry := range file.Entries {
+		if _, standard := defaults[entry.Key]; standard || entry.Key == "XDG_RUNTIME_DIR" {
+			continue
+		}
+		if _, ok := registered[entry.Key]; ok {
+			enabled[entry.Key] = true
+			continue
+		}
+		report(entry.Line, entry.KeyColumn, "unknown variable %s%s; register it in extras.dirs", entry.Key, suggestKey(entry.Key, known))
+	}
+
+	var logEntries []string
+	for _, extra := range extras {
+		state := "off"
+		if enabled[extra.Key] {
+			state = "on"
+			defaults[extra.Key] = Origin{Key: extra.Key, Value: extra.Default, Source: SourceDefault, Raw: extra.Default}
+		}
+		logEntries = append(logEntries, fmt.Sprintf("%s=%s [%s, %s] %s", extra.Key, extra.Default, state, extra.Origin, extra.Description))
+	}
+	x.logger.Debug("Registered extra directories:\n%s", strings.Join(logEntries, "\n"))
+	return diagnostics
+}
+
+// ResolveName maps a user-supplied variable name onto a key of dirs. Full
+// names (XDG_DOWNLOAD_DIR) are taken as-is; short names as accepted by
+// xdg-user-dir (DOWNLOAD, CACHE) are tried with the _DIR and _HOME suffixes.
+func ResolveName(name string, dirs map[string]string) (string, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "" {
+		return "", false
+	}
+	candidates := []string{name}
+	if !strings.HasPrefix(name, "XDG_") {
+		candidates = []string{"XDG_" + name + "_DIR", "XDG_" + name + "_HOME", "XDG_" + name}
+	}
+	for _, key := range candidates {
+		if _, ok := dirs[key]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// ReadGeneratedDirs returns the values recorded in generated.dirs by the last
+// export, or nil if it has never been written.
+func (x *XDGDirs) ReadGeneratedDirs() (map[string]string, error) {
+	path, err := x.GeneratedDirsPath()
+	if err != nil {
+		return nil, err
+	}
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if lines == nil {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	generated := make(map[string]string)
+	for _, line := range lines {
+		if key := lineKey(line); key != "" {
+			generated[key] = strings.Trim(strings.SplitN(line, "=", 2)[1], "\"")
 		}
-		configHome = filepath.Join(homeDir, ".config")
 	}
-	xdgConfigDir := filepath.Join(configHome, "xdg")
+	return generated, nil
+}
+
+// WriteUserDirs writes generated.dirs. Shells starting together all write
+// it, so the whole update - compare, back up, replace - happens under an
+// advisory lock, and the new content goes to a temporary file that is synced
+// and renamed over the old one: a reader or a crash sees the previous
+// version or the new one, never a truncated or interleaved mix.
+func (x *XDGDirs) WriteUserDirs(userDirs map[string]string) error {
+	xdgConfigDir, err := x.configDir()
+	if err != nil {
+		return err
+	}
 	if err := os.MkdirAll(xdgConfigDir, 0755); err != nil {
-		x.logger.Error("Failed to create XDG config directory: %v", err)
+		x.logger.With("path", xdgConfigDir, "error", err).Error("Failed to create XDG config directory")
 		return fmt.Errorf("failed to create XDG config directory: %w", err)
 	}
-	userDirsFile := filepath.Clean(filepath.Join(configHome, "xdg", "generated.dirs"))
+	userDirsFile := filepath.Clean(filepath.Join(xdgConfigDir, "generated.dirs"))
+	content := formatGeneratedDirs(userDirs)
 
-	file, err := os.OpenFile(userDirsFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	stateDir, err := x.StateDir()
 	if err != nil {
-		x.logger.Error("Failed to create generated.dirs file: %v", err)
-		return fmt.Errorf("failed to create generated.dirs file: %w", err)
+		return err
 	}
-	defer file.Close()
-
-	_, err = file.WriteString("# This file is written by xdg-dirs. Do not edit: it is regenerated on\n# every run. To override a directory, edit user.dirs in the same folder.\n# Entries are sorted by name so identical state diffs byte-identically.\n#\n")
+	lock, err := fslock.Acquire(filepath.Join(stateDir, "generated.dirs.lock"))
 	if err != nil {
-		x.logger.Error("Failed to write to generated.dirs file: %v", err)
+		x.logger.With("path", userDirsFile, "error", err).Error("Failed to lock generated.dirs")
+		return fmt.Errorf("failed to lock generated.dirs: %w", err)
+	}
+	defer lock.Release()
+
+	// Keep the previous version, but only when it changes: a backup per
+	// shell start would push the interesting ones out of the retention.
+	previous, err := os.ReadFile(userDirsFile)
+	switch {
+	case err == nil && bytes.Equal(previous, content):
+		x.logger.Debug("generated.dirs is up to date")
+		return nil
+	case err == nil:
+		if _, err := x.SaveBackup(BackupGenerated, previous); err != nil {
+			x.logger.With("path", userDirsFile, "error", err).Warn("Failed to back up generated.dirs")
+		}
+	case !os.IsNotExist(err):
+		x.logger.With("path", userDirsFile, "error", err).Warn("Failed to read generated.dirs")
+	}
+
+	if err := writeFileAtomic(userDirsFile, content, 0644); err != nil {
+		x.logger.With("path", userDirsFile, "error", err).Error("Failed to write to generated.dirs file")
 		return fmt.Errorf("failed to write to generated.dirs file: %w", err)
 	}
 
+	x.logger.Debug("Generated generated.dirs")
+	return nil
+}
+
+// formatGeneratedDirs renders generated.dirs: a header, then every variable
+// sorted by name.
+func formatGeneratedDirs(userDirs map[string]string) []byte {
+	var b bytes.Buffer
+	b.WriteString("# This file is written by xdg-dirs. Do not edit: it is regenerated on\n# every run. To override a directory, edit user.dirs in the same folder.\n# Entries are sorted by name so identical state diffs byte-identically.\n#\n")
+
 	keys := make([]string, 0, len(userDirs))
 	for key := range userDirs {
 		keys = append(keys, key)
 	}
 	sort.Strings(keys)
 	for _, key := range keys {
-		_, err = file.WriteString(fmt.Sprintf("%s=\"%s\"\n", key, userDirs[key]))
-		if err != nil {
-			x.logger.Error("Failed to write to generated.dirs file: %v", err)
-			return fmt.Errorf("failed to write to generated.dirs file: %w", err)
-		}
+		fmt.Fprintf(&b, "%s=\"%s\"\n", key, userDirs[key])
 	}
-
-	x.logger.Debug("Generated generated.dirs")
-	return nil
+	return b.Bytes()
 }
@@ -1,10 +1,14 @@
 package xdgdirs
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/adriangalilea/xdg-dirs/internal/logger"
@@ -111,3 +115,774 @@ func TestPlatformSpecificDefaults(t *testing.T) {
 		}
 	}
 }
+
+// Test 5: get accepts both xdg-user-dir short names and full variable names
+func TestResolveName(t *testing.T) {
+	dirs := map[string]string{
+		"XDG_DOWNLOAD_DIR": "/d",
+		"XDG_CACHE_HOME":   "/c",
+		"XDG_BIN_HOME":     "/b",
+	}
+	cases := map[string]string{
+		"DOWNLOAD":         "XDG_DOWNLOAD_DIR",
+		"download":         "XDG_DOWNLOAD_DIR",
+		"XDG_DOWNLOAD_DIR": "XDG_DOWNLOAD_DIR",
+		"CACHE":            "XDG_CACHE_HOME",
+		"BIN_HOME":         "XDG_BIN_HOME",
+	}
+	for name, want := range cases {
+		if got, ok := ResolveName(name, dirs); !ok || got != want {
+			t.Errorf("ResolveName(%q) = %q, %v; want %q", name, got, ok, want)
+		}
+	}
+	for _, name := range []string{"", "NOPE", "XDG_DOWNLOAD"} {
+		if got, ok := ResolveName(name, dirs); ok {
+			t.Errorf("ResolveName(%q) resolved to %q", name, got)
+		}
+	}
+}
+
+// Test 6: set/unset only touch the key's lines and keep everything else
+func TestSetAndUnsetPreserveFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmpDir)
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	defer os.Unsetenv("HOME")
+
+	xdgDir := filepath.Join(tmpDir, "xdg")
+	os.MkdirAll(xdgDir, 0755)
+	original := `# my dirs
+XDG_DESKTOP_DIR="$HOME/Desktop"  # keep me
+something we do not understand
+XDG_CACHE_HOME="$HOME/.cache"
+`
+	path := filepath.Join(xdgDir, "user.dirs")
+	os.WriteFile(path, []byte(original), 0600)
+
+	log := logger.NewLogger(false, filepath.Join(tmpDir, "test.log"))
+	x := NewXDGDirs(log)
+
+	if err := x.SetUserDir("XDG_DESKTOP_DIR", filepath.Join(tmpDir, "desk")); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.SetUserDir("XDG_PROJECTS_DIR", "/srv/projects"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.UnsetUserDir("XDG_CACHE_HOME"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := os.ReadFile(path)
+	want := `# my dirs
+XDG_DESKTOP_DIR="$HOME/desk"  # keep me
+something we do not understand
+XDG_PROJECTS_DIR="/srv/projects"
+`
+	if string(got) != want {
+		t.Errorf("user.dirs after edit:\n%s\nwant:\n%s", got, want)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("file mode changed to %v", info.Mode().Perm())
+	}
+
+	if removed, _ := x.UnsetUserDir("XDG_CACHE_HOME"); removed {
+		t.Error("unset of a missing key reported a removal")
+	}
+	if err := x.SetUserDir("XDG_MUSIC_DIR", `/bad"path`); err == nil {
+		t.Error("value with a quote was accepted")
+	}
+
+	// A $ in the path is stored escaped, so it reads back literally.
+	t.Setenv("pics", "/expanded")
+	literal := filepath.Join(tmpDir, "my $pics")
+	if err := x.SetUserDir("XDG_PICTURES_DIR", literal); err != nil {
+		t.Fatal(err)
+	}
+	if dirs, _ := x.ReadUserDirs(); dirs["XDG_PICTURES_DIR"] != literal {
+		t.Errorf("XDG_PICTURES_DIR = %q, want %q", dirs["XDG_PICTURES_DIR"], literal)
+	}
+}
+
+// Test 7: search paths default to the inherited lists or else the spec, can be
+// prepended and appended to by referring to themselves, and come out without
+// empties or duplicates
+func TestSearchPaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmpDir)
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	defer os.Unsetenv("HOME")
+
+	xdgDir := filepath.Join(tmpDir, "xdg")
+	os.MkdirAll(xdgDir, 0755)
+	os.WriteFile(filepath.Join(xdgDir, "user.dirs"), []byte(`XDG_DATA_DIRS="$HOME/share::$XDG_DATA_DIRS"
+XDG_DATA_DIRS="$XDG_DATA_DIRS:/usr/share/:/opt/share"
+`), 0644)
+
+	log := logger.NewLogger(false, filepath.Join(tmpDir, "test.log"))
+	dirs, _ := NewXDGDirs(log).ReadUserDirs()
+
+	wantData := tmpDir + "/share:/usr/local/share:/usr/share:/opt/share"
+	if dirs["XDG_DATA_DIRS"] != wantData {
+		t.Errorf("XDG_DATA_DIRS = %q, want %q", dirs["XDG_DATA_DIRS"], wantData)
+	}
+	if dirs["XDG_CONFIG_DIRS"] != "/etc/xdg" {
+		t.Errorf("XDG_CONFIG_DIRS = %q, want the spec default", dirs["XDG_CONFIG_DIRS"])
+	}
+
+	// The lists the system provides are the baseline instead of the spec's.
+	x := NewXDGDirs(log)
+	x.SetInherited(map[string]string{
+		"XDG_DATA_DIRS":   "/nix/share:/usr/share",
+		"XDG_CONFIG_DIRS": "/nix/etc/xdg",
+	})
+	dirs, _ = x.ReadUserDirs()
+	wantData = tmpDir + "/share:/nix/share:/usr/share:/opt/share"
+	if dirs["XDG_DATA_DIRS"] != wantData {
+		t.Errorf("inherited XDG_DATA_DIRS: got %q, want %q", dirs["XDG_DATA_DIRS"], wantData)
+	}
+	if dirs["XDG_CONFIG_DIRS"] != "/nix/etc/xdg" {
+		t.Errorf("inherited XDG_CONFIG_DIRS: got %q, want it kept", dirs["XDG_CONFIG_DIRS"])
+	}
+}
+
+// Test 8: inherited values only win where the precedence policy says so
+func TestPrecedencePolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmpDir)
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	defer os.Unsetenv("HOME")
+
+	xdgDir := filepath.Join(tmpDir, "xdg")
+	os.MkdirAll(xdgDir, 0755)
+	userDirsPath := filepath.Join(xdgDir, "user.dirs")
+	os.WriteFile(userDirsPath, []byte(`XDG_CACHE_HOME="/user/cache"
+XDG_MUSIC_DIR="/user/music"
+`), 0644)
+
+	inherited := map[string]string{
+		"XDG_RUNTIME_DIR": "/run/user/1000",
+		"XDG_CACHE_HOME":  "/env/cache",
+		"XDG_MUSIC_DIR":   "/env/music",
+	}
+	log := logger.NewLogger(false, filepath.Join(tmpDir, "test.log"))
+	x := NewXDGDirs(log)
+	x.SetInherited(inherited)
+
+	// Default policy: runtime dir kept from the environment, the rest ignored.
+	dirs, _ := x.ReadUserDirs()
+	if dirs["XDG_RUNTIME_DIR"] != "/run/user/1000" || dirs["XDG_CACHE_HOME"] != "/user/cache" {
+		t.Errorf("default policy: runtime %q, cache %q", dirs["XDG_RUNTIME_DIR"], dirs["XDG_CACHE_HOME"])
+	}
+
+	// A per-variable directive in user.dirs.
+	os.WriteFile(userDirsPath, []byte(`@precedence.XDG_CACHE_HOME="env,user,default"
+XDG_CACHE_HOME="/user/cache"
+XDG_MUSIC_DIR="/user/music"
+`), 0644)
+	dirs, _ = x.ReadUserDirs()
+	if dirs["XDG_CACHE_HOME"] != "/env/cache" || dirs["XDG_MUSIC_DIR"] != "/user/music" {
+		t.Errorf("per-key directive: cache %q, music %q", dirs["XDG_CACHE_HOME"], dirs["XDG_MUSIC_DIR"])
+	}
+
+	// The flag overrides the global order.
+	x.SetPrecedence([]Source{SourceEnv, SourceUser, SourceDefault})
+	dirs, _ = x.ReadUserDirs()
+	if dirs["XDG_MUSIC_DIR"] != "/env/music" {
+		t.Errorf("global override: music %q", dirs["XDG_MUSIC_DIR"])
+	}
+
+	if _, err := ParseOrder("env,bogus"); err == nil {
+		t.Error("ParseOrder accepted an unknown source")
+	}
+}
+
+// Test 9: Resolve keeps the origin of every winning value, the variables its
+// expansion read and the empty values it fell back from
+func TestResolveOrigins(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmpDir)
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	defer os.Unsetenv("HOME")
+
+	xdgDir := filepath.Join(tmpDir, "xdg")
+	os.MkdirAll(xdgDir, 0755)
+	userDirsPath := filepath.Join(xdgDir, "user.dirs")
+	os.WriteFile(userDirsPath, []byte(`# my dirs
+XDG_CACHE_HOME="$HOME/.local/cache"  # clutter-free home
+XDG_MUSIC_DIR=""
+XDG_DOWNLOAD_DIR="${XDG_CACHE_HOME}/dl"
+`), 0644)
+
+	log := logger.NewLogger(false, filepath.Join(tmpDir, "test.log"))
+	x := NewXDGDirs(log)
+	x.SetInherited(map[string]string{"XDG_RUNTIME_DIR": "/run/user/1000"})
+	origins, err := x.Resolve()
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	want := Origin{
+		Key:    "XDG_CACHE_HOME",
+		Value:  filepath.Join(tmpDir, ".local", "cache"),
+		Source: SourceUser,
+		File:   userDirsPath,
+		Line:   2,
+		Raw:    "$HOME/.local/cache",
+		Refs:   []Ref{{Name: "HOME", Value: tmpDir, Source: SourceEnv}},
+	}
+	if got := origins["XDG_CACHE_HOME"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("XDG_CACHE_HOME origin = %+v, want %+v", got, want)
+	}
+	if got := origins["XDG_RUNTIME_DIR"]; got.Source != SourceEnv || got.File != "" {
+		t.Errorf("XDG_RUNTIME_DIR origin = %+v, want env", got)
+	}
+
+	// An empty value in user.dirs falls back to the default, and says so.
+	got := origins["XDG_MUSIC_DIR"]
+	if got.Source != SourceDefault || len(got.Skipped) != 1 || got.Skipped[0].Source != SourceUser || got.Skipped[0].Line != 3 {
+		t.Errorf("XDG_MUSIC_DIR origin = %+v, want default after the empty line 3", got)
+	}
+	// A reference to a managed variable names the source of its value.
+	wantRefs := []Ref{{Name: "XDG_CACHE_HOME", Value: want.Value, Source: SourceUser}}
+	if got := origins["XDG_DOWNLOAD_DIR"].Refs; !reflect.DeepEqual(got, wantRefs) {
+		t.Errorf("XDG_DOWNLOAD_DIR refs = %+v, want %+v", got, wantRefs)
+	}
+}
+
+// Test 10: the parser locates every malformed line by line and column
+func TestParseUserDirsDiagnostics(t *testing.T) {
+	content := `# comment
+XGD_CACHE_HOME="$HOME/x"
+XDG_MUSIC_DIR="/music
+XDG_DESKTOP_DIR=/a b
+  XDG_DOWNLOAD_DIR = "/dl"  # fine
+XDG_DOWNLOAD_DIR='/dl2'
+XDG_DATA_DIRS="/a:$XDG_DATA_DIRS"
+XDG_DATA_DIRS="$XDG_DATA_DIRS:/b"
+XDG_CAHCE_HOME=/c
+=oops
+XDG_VIDEOS_DIR="say \"hi\""
+`
+	f := ParseUserDirs("user.dirs", []byte(content))
+
+	want := []string{
+		"user.dirs:2:1: warning: unknown key XGD_CACHE_HOME, line ignored (did you mean XDG_CACHE_HOME?)",
+		"user.dirs:3:15: error: unterminated \" quote",
+		"user.dirs:4:19: warning: unquoted value \"/a b\" contains blanks; quote it",
+		"user.dirs:6:1: warning: duplicate key XDG_DOWNLOAD_DIR, already set on line 5; this line wins",
+		"user.dirs:10:1: error: expected a variable name, found \"=oops\"",
+	}
+	var got []string
+	for _, d := range f.Diagnostics {
+		got = append(got, d.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var keys []string
+	for _, e := range f.Entries {
+		keys = append(keys, fmt.Sprintf("%s=%s", e.Key, e.Raw))
+	}
+	wantKeys := []string{
+		"XDG_DESKTOP_DIR=/a b", "XDG_DOWNLOAD_DIR=/dl", "XDG_DOWNLOAD_DIR=/dl2",
+		"XDG_DATA_DIRS=/a:$XDG_DATA_DIRS", "XDG_DATA_DIRS=$XDG_DATA_DIRS:/b",
+		"XDG_CAHCE_HOME=/c", `XDG_VIDEOS_DIR=say "hi"`,
+	}
+	if strings.Join(keys, "\n") != strings.Join(wantKeys, "\n") {
+		t.Errorf("entries:\n%s\nwant:\n%s", strings.Join(keys, "\n"), strings.Join(wantKeys, "\n"))
+	}
+}
+
+// Test 11: strict mode turns diagnostics into an error
+func TestStrictMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmpDir)
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	defer os.Unsetenv("HOME")
+
+	os.MkdirAll(filepath.Join(tmpDir, "xdg"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "xdg", "user.dirs"), []byte("XGD_CACHE_HOME=/c\nXDG_MUSIC_DIR=/m\n"), 0644)
+
+	x := NewXDGDirs(logger.NewLogger(false, filepath.Join(tmpDir, "test.log")))
+	dirs, err := x.ReadUserDirs()
+	if err != nil || dirs["XDG_MUSIC_DIR"] != "/m" {
+		t.Fatalf("non-strict read: %v, XDG_MUSIC_DIR=%q", err, dirs["XDG_MUSIC_DIR"])
+	}
+
+	x.SetStrict(true)
+	_, err = x.ReadUserDirs()
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || len(parseErr.Diagnostics) != 1 {
+		t.Fatalf("strict read returned %v", err)
+	}
+}
+
+// Test 12: values refer to each other, in any order, and cycles are reported
+func TestExpansion(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmpDir)
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	defer os.Unsetenv("HOME")
+
+	os.MkdirAll(filepath.Join(tmpDir, "xdg"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "xdg", "user.dirs"), []byte(`XDG_PROJECTS_DIR="${XDG_DATA_HOME}/projects"
+XDG_DATA_HOME="~/data"
+XDG_MUSIC_DIR="${XDG_NO_SUCH_DIR:-~/Music2}"
+XDG_VIDEOS_DIR="${XDG_NO_SUCH_DIR:?set it first}"
+XDG_DESKTOP_DIR="$XDG_DOCUMENTS_DIR/desk"
+XDG_DOCUMENTS_DIR="$XDG_DESKTOP_DIR/docs"
+XDG_TEMPLATES_DIR="$XDG_CACHE_HOME/templates"
+XDG_PUBLICSHARE_DIR="\$HOME"
+`), 0644)
+
+	x := NewXDGDirs(logger.NewLogger(false, filepath.Join(tmpDir, "test.log")))
+	origins, diagnostics, err := x.ResolveAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"XDG_PROJECTS_DIR":    filepath.Join(tmpDir, "data", "projects"),
+		"XDG_DATA_HOME":       filepath.Join(tmpDir, "data"),
+		"XDG_MUSIC_DIR":       filepath.Join(tmpDir, "Music2"),
+		"XDG_TEMPLATES_DIR":   filepath.Join(tmpDir, ".cache", "templates"),
+		"XDG_PUBLICSHARE_DIR": "$HOME",
+		// Entries that fail fall back to the defaults.
+		"XDG_VIDEOS_DIR":  defaultDirs(tmpDir)["XDG_VIDEOS_DIR"],
+		"XDG_DESKTOP_DIR": filepath.Join(tmpDir, "Desktop"),
+	}
+	for key, value := range want {
+		if got := origins[key].Value; got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	var messages []string
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			messages = append(messages, fmt.Sprintf("%d:%d %s", d.Line, d.Column, d.Message))
+		}
+	}
+	wantMessages := []string{
+		"4:19 cannot expand XDG_VIDEOS_DIR: XDG_NO_SUCH_DIR: set it first",
+		"5:18 cannot expand XDG_DESKTOP_DIR: reference cycle: XDG_DESKTOP_DIR -> XDG_DOCUMENTS_DIR -> XDG_DESKTOP_DIR",
+		"6:20 cannot expand XDG_DOCUMENTS_DIR: reference cycle: XDG_DESKTOP_DIR -> XDG_DOCUMENTS_DIR -> XDG_DESKTOP_DIR",
+	}
+	if strings.Join(messages, "\n") != strings.Join(wantMessages, "\n") {
+		t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(messages, "\n"), strings.Join(wantMessages, "\n"))
+	}
+}
+
+// Test 13: registered extras are exported once enabled, unknown keys flagged
+func TestExtrasRegistry(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmpDir)
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	defer os.Unsetenv("HOME")
+
+	xdgDir := filepath.Join(tmpDir, "xdg")
+	os.MkdirAll(xdgDir, 0755)
+	os.WriteFile(filepath.Join(xdgDir, "extras.dirs"), []byte(`XDG_ACME_DIR="${XDG_DATA_HOME}/acme"  # ACME shared drive
+XDG_CACHE_HOME="/nope"
+@precedence="env,user,default"
+`), 0644)
+	os.WriteFile(filepath.Join(xdgDir, "user.dirs"), []byte(`@extras="XDG_NOPE XDG_BIN_HOME XDG_NOPE_DIR XDG_NOPE"
+XDG_ACME_DIR=""
+XDG_PROJECTS_DIR="~/src"
+XDG_CAHCE_HOME="/c"
+`), 0644)
+
+	x := NewXDGDirs(logger.NewLogger(false, filepath.Join(tmpDir, "test.log")))
+	extras, _, err := x.Registry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var acme Extra
+	for _, extra := range extras {
+		if extra.Key == "XDG_ACME_DIR" {
+			acme = extra
+		}
+	}
+	if acme.Default != filepath.Join(tmpDir, ".local", "share", "acme") || acme.Description != "ACME shared drive" {
+		t.Errorf("extras.dirs entry = %+v", acme)
+	}
+
+	origins, diagnostics, err := x.ResolveAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"XDG_BIN_HOME":     filepath.Join(tmpDir, ".local", "bin"), // @extras
+		"XDG_ACME_DIR":     acme.Default,                           // empty value: the default
+		"XDG_PROJECTS_DIR": filepath.Join(tmpDir, "src"),           // assigned
+		"XDG_CACHE_HOME":   filepath.Join(tmpDir, ".cache"),        // extras.dirs can't override
+		"XDG_CAHCE_HOME":   "/c",                                   // passed through
+	}
+	for key, value := range want {
+		if got := origins[key].Value; got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if _, ok := origins["XDG_SCREENSHOTS_DIR"]; ok {
+		t.Error("XDG_SCREENSHOTS_DIR exported without being enabled")
+	}
+
+	var messages []string
+	for _, d := range diagnostics {
+		messages = append(messages, fmt.Sprintf("%s:%d:%d %s", filepath.Base(d.File), d.Line, d.Column, d.Message))
+	}
+	wantMessages := []string{
+		"extras.dirs:2:1 XDG_CACHE_HOME is a standard variable, not an extra",
+		"extras.dirs:3:1 @precedence has no effect in extras.dirs; it belongs in user.dirs",
+		"user.dirs:1:10 XDG_NOPE in @extras is not a registered extra",
+		"user.dirs:1:32 XDG_NOPE_DIR in @extras is not a registered extra",
+		"user.dirs:1:45 XDG_NOPE in @extras is not a registered extra",
+		"user.dirs:4:1 unknown variable XDG_CAHCE_HOME (did you mean XDG_CACHE_HOME?); register it in extras.dirs",
+	}
+	if strings.Join(messages, "\n") != strings.Join(wantMessages, "\n") {
+		t.Errorf("diagnostics:\n%s\nwant:\n%s", strings.Join(messages, "\n"), strings.Join(wantMessages, "\n"))
+	}
+}
+
+// Test 14: @apps selects known apps and flags unknown ones
+func TestSelectedApps(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmpDir)
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	defer os.Unsetenv("HOME")
+
+	xdgDir := filepath.Join(tmpDir, "xdg")
+	os.MkdirAll(xdgDir, 0755)
+	os.WriteFile(filepath.Join(xdgDir, "user.dirs"), []byte(`@apps="less cargo Cargo gnupgg cargo"
+`), 0644)
+
+	x := NewXDGDirs(logger.NewLogger(false, filepath.Join(tmpDir, "test.log")))
+	names, err := x.SelectedApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(names) != "[cargo less]" {
+		t.Errorf("SelectedApps = %v, want [cargo less]", names)
+	}
+
+	_, diagnostics, err := x.ResolveAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range diagnostics {
+		got = append(got, fmt.Sprintf("%d:%d %s", d.Line, d.Column, d.Message))
+	}
+	want := []string{
+		"1:19 Cargo in @apps is not a known app (did you mean cargo?)",
+		"1:25 gnupgg in @apps is not a known app (did you mean gnupg?)",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("diagnostics = %q, want %q", got, want)
+	}
+}
+
+// Test 15: user-dirs.dirs is written in the xdg-user-dirs format, in the
+// exported XDG_CONFIG_HOME, and a foreign one is backed up before it is
+// replaced
+func TestWriteCompatUserDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("HOME")
+
+	userDirs := map[string]string{
+		"XDG_CACHE_HOME":    filepath.Join(tmpDir, ".cache"),
+		"XDG_DOWNLOAD_DIR":  filepath.Join(tmpDir, "dl"),
+		"XDG_MUSIC_DIR":     "/data/My \"Music\"",
+		"XDG_DESKTOP_DIR":   tmpDir,
+		"XDG_PROJECTS_DIR":  filepath.Join(tmpDir, "src", "$work"),
+		"XDG_RUNTIME_DIR":   "/run/user/1000",
+		"XDG_TEMPLATES_DIR": "relative/path",
+	}
+	lines := strings.Split(string(FormatCompatUserDirs(userDirs, tmpDir)), "\n")
+	var entries []string
+	for _, line := range lines {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	want := []string{
+		`XDG_DESKTOP_DIR="$HOME/"`,
+		`XDG_DOWNLOAD_DIR="$HOME/dl"`,
+		`XDG_MUSIC_DIR="/data/My \"Music\""`,
+		`XDG_PROJECTS_DIR="$HOME/src/\$work"`,
+	}
+	if fmt.Sprint(entries) != fmt.Sprint(want) {
+		t.Errorf("entries = %q, want %q", entries, want)
+	}
+
+	legacy := filepath.Join(tmpDir, ".config", "user-dirs.dirs")
+	os.MkdirAll(filepath.Dir(legacy), 0755)
+	os.WriteFile(legacy, []byte("XDG_DOWNLOAD_DIR=\"$HOME/old\"\n"), 0644)
+
+	x := NewXDGDirs(logger.NewLogger(false, filepath.Join(tmpDir, "test.log")))
+	if err := x.WriteCompatUserDirs(userDirs); err != nil {
+		t.Fatal(err)
+	}
+	backup, ok, err := x.LatestBackup(BackupUserDirs)
+	if err != nil || !ok {
+		t.Fatalf("no backup of user-dirs.dirs: %v", err)
+	}
+	if got, _ := os.ReadFile(backup.Path); string(got) != "XDG_DOWNLOAD_DIR=\"$HOME/old\"\n" {
+		t.Errorf("backup = %q", got)
+	}
+	if got, _ := os.ReadFile(legacy); !strings.Contains(string(got), `XDG_DOWNLOAD_DIR="$HOME/dl"`) {
+		t.Errorf("user-dirs.dirs = %q", got)
+	}
+
+	// Our own file is replaced without another backup.
+	userDirs["XDG_DOWNLOAD_DIR"] = filepath.Join(tmpDir, "Downloads")
+	if err := x.WriteCompatUserDirs(userDirs); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := x.Backups(); len(backups) != 1 {
+		t.Errorf("own user-dirs.dirs was backed up: %+v", backups)
+	}
+	if got, _ := os.ReadFile(legacy); !strings.Contains(string(got), `XDG_DOWNLOAD_DIR="$HOME/Downloads"`) {
+		t.Errorf("user-dirs.dirs = %q", got)
+	}
+
+	// It follows an XDG_CONFIG_HOME moved in user.dirs, where GTK will look.
+	os.MkdirAll(filepath.Join(tmpDir, ".config", "xdg"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, ".config", "xdg", "user.dirs"), []byte(`XDG_CONFIG_HOME="$HOME/etc"`+"\n"), 0644)
+	x = NewXDGDirs(logger.NewLogger(false, filepath.Join(tmpDir, "test.log")))
+	moved := filepath.Join(tmpDir, "etc", "user-dirs.dirs")
+	if path, _ := x.LegacyUserDirsPath(); path != moved {
+		t.Errorf("LegacyUserDirsPath = %q, want %q", path, moved)
+	}
+	userDirs["XDG_CONFIG_HOME"] = filepath.Join(tmpDir, "etc")
+	if err := x.WriteCompatUserDirs(userDirs); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(moved); !strings.Contains(string(got), `XDG_DOWNLOAD_DIR="$HOME/Downloads"`) {
+		t.Errorf("user-dirs.dirs in the moved XDG_CONFIG_HOME = %q", got)
+	}
+}
+
+// Test 16: the legacy user-dirs.dirs is imported once, without its defaults
+// and without overriding user.dirs
+func TestImportLegacy(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("HOME")
+
+	configDir := filepath.Join(tmpDir, ".config")
+	os.MkdirAll(filepath.Join(configDir, "xdg"), 0755)
+	os.WriteFile(filepath.Join(configDir, "user-dirs.dirs"), []byte(`# written by xdg-user-dirs-update
+XDG_DESKTOP_DIR="$HOME/Desktop"
+XDG_DOWNLOAD_DIR="$HOME/dl"
+XDG_MUSIC_DIR="/data/music"
+XDG_PICTURES_DIR="$HOME/my \$pics"
+XDG_VIDEOS_DIR="$HOME/vids"
+XDG_TEMPLATES_DIR="relative"
+`), 0644)
+	os.WriteFile(filepath.Join(configDir, "xdg", "user.dirs"), []byte("XDG_VIDEOS_DIR=\"/v\"\n"), 0644)
+
+	x := NewXDGDirs(logger.NewLogger(false, filepath.Join(tmpDir, "test.log")))
+	plan, err := x.PlanImport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added, kept []string
+	for _, entry := range plan.Entries {
+		added = append(added, entry.Key+"="+entry.Value)
+	}
+	for _, entry := range plan.Kept {
+		kept = append(kept, entry.Key)
+	}
+	wantAdded := []string{
+		"XDG_DOWNLOAD_DIR=" + filepath.Join(tmpDir, "dl"),
+		"XDG_MUSIC_DIR=/data/music",
+		"XDG_PICTURES_DIR=" + filepath.Join(tmpDir, "my $pics"),
+	}
+	if fmt.Sprint(added) != fmt.Sprint(wantAdded) || fmt.Sprint(kept) != "[XDG_VIDEOS_DIR]" {
+		t.Errorf("plan = %q, kept %q", added, kept)
+	}
+
+	if done, _ := x.Imported(); done {
+		t.Fatal("imported before ApplyImport")
+	}
+	if err := x.ApplyImport(plan); err != nil {
+		t.Fatal(err)
+	}
+	if done, _ := x.Imported(); !done {
+		t.Error("ApplyImport did not record the import")
+	}
+	got, err := x.ReadUserDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{
+		"XDG_DOWNLOAD_DIR": filepath.Join(tmpDir, "dl"),
+		"XDG_PICTURES_DIR": filepath.Join(tmpDir, "my $pics"),
+		"XDG_VIDEOS_DIR":   "/v",
+	} {
+		if got[key] != value {
+			t.Errorf("after import: %s = %q, want %q", key, got[key], value)
+		}
+	}
+
+	// Once user-dirs.dirs is ours, the backup of the original is read.
+	if err := x.WriteCompatUserDirs(got); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = x.PlanImport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, _, _ := x.LatestBackup(BackupUserDirs)
+	if plan.Source != backup.Path || len(plan.Entries) != 0 {
+		t.Errorf("second plan = %+v", plan)
+	}
+}
+
+// Test 17: backups are numbered, pruned beyond the retention, and restoring
+// one saves the file it replaces
+func TestBackups(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("HOME")
+
+	x := NewXDGDirs(logger.NewLogger(false, filepath.Join(tmpDir, "test.log")))
+	for i := 1; i <= BackupRetention+2; i++ {
+		if err := x.WriteUserDirs(map[string]string{"XDG_MUSIC_DIR": fmt.Sprintf("/music/%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The same content again is neither rewritten nor backed up.
+	if err := x.WriteUserDirs(map[string]string{"XDG_MUSIC_DIR": fmt.Sprintf("/music/%d", BackupRetention+2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := x.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first write had nothing to back up; the oldest one was pruned.
+	if len(backups) != BackupRetention || backups[0].ID != 2 || backups[len(backups)-1].ID != BackupRetention+1 {
+		t.Fatalf("backups = %+v", backups)
+	}
+	oldest, _ := os.ReadFile(backups[0].Path)
+	if !strings.Contains(string(oldest), `XDG_MUSIC_DIR="/music/2"`) {
+		t.Errorf("backup 2 holds %q", oldest)
+	}
+
+	restored, err := x.Restore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(restored.Target); string(got) != string(oldest) {
+		t.Errorf("restored %q", got)
+	}
+	latest, _, _ := x.LatestBackup(BackupGenerated)
+	if content, _ := os.ReadFile(latest.Path); latest.ID != BackupRetention+2 || !strings.Contains(string(content), fmt.Sprintf("/music/%d", BackupRetention+2)) {
+		t.Errorf("replaced file not backed up: %+v", latest)
+	}
+	if _, err := x.Restore(1); err == nil {
+		t.Error("restored a pruned backup")
+	}
+}
+
+// Test 18: parallel writers never leave a truncated or interleaved
+// generated.dirs; readers always see one complete version
+func TestWriteUserDirsConcurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv("HOME", tmpDir)
+	defer os.Unsetenv("HOME")
+
+	const writers, keys = 32, 40
+	version := func(n int) map[string]string {
+		dirs := make(map[string]string, keys)
+		for k := 0; k < keys; k++ {
+			dirs[fmt.Sprintf("XDG_KEY%02d_DIR", k)] = fmt.Sprintf("/version/%03d/%s", n, strings.Repeat("x", 4000))
+		}
+		return dirs
+	}
+	// check returns "" if content is exactly one complete version.
+	check := func(content []byte) string {
+		generated := ParseUserDirs("generated.dirs", content)
+		if len(generated.Entries) != keys || len(generated.Diagnostics) != 0 {
+			return fmt.Sprintf("%d entries, %d diagnostics", len(generated.Entries), len(generated.Diagnostics))
+		}
+		first := generated.Entries[0].Raw[:len("/version/000")]
+		for _, entry := range generated.Entries {
+			if !strings.HasPrefix(entry.Raw, first) || len(entry.Raw) != len(first)+1+4000 {
+				return fmt.Sprintf("%s... mixed with %s...", entry.Raw[:len(first)], first)
+			}
+		}
+		return ""
+	}
+
+	path := filepath.Join(tmpDir, ".config", "xdg", "generated.dirs")
+	done := make(chan struct{})
+	readerErr := make(chan string, 1)
+	go func() {
+		defer close(readerErr)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				continue // not written yet
+			}
+			if problem := check(content); problem != "" {
+				readerErr <- problem
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for n := 0; n < writers; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			x := NewXDGDirs(logger.NewLogger(false, filepath.Join(tmpDir, "test.log")))
+			for i := 0; i < 5; i++ {
+				if err := x.WriteUserDirs(version(n*5 + i)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+	close(done)
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if problem := <-readerErr; problem != "" {
+		t.Errorf("reader saw a partial generated.dirs: %s", problem)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problem := check(content); problem != "" {
+		t.Errorf("final generated.dirs: %s", problem)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".generated.dirs.tmp-*"))
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}